@@ -0,0 +1,144 @@
+package simulator_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator"
+	"github.com/AlexsanderHamir/GoFlow/simulator/testkit"
+)
+
+func TestDroppedExcludesConsumedAndInFlight(t *testing.T) {
+	sim := testkit.NewPipeline(t, testkit.PipelineOptions{Workers: 2, RoutineNum: 2, BufferSize: 4})
+	testkit.RunFor(t, sim, 50*time.Millisecond)
+
+	testkit.AssertNoDrops(t, sim)
+
+	stages := sim.GetStages()
+	sink := stages[len(stages)-1].GetCounts()
+	if sink.Consumed == 0 || sink.Consumed != sink.Received {
+		t.Errorf("Expected the sink to consume all %d received items, got %d", sink.Received, sink.Consumed)
+	}
+	testkit.AssertAccounting(t, sim)
+}
+
+// everyOther filters out every other item it sees, counting its calls.
+func everyOther(calls *atomic.Uint64) func(any) (any, error) {
+	return func(item any) (any, error) {
+		if calls.Add(1)%2 == 0 {
+			return nil, fmt.Errorf("even call: %w", simulator.ErrFiltered)
+		}
+		return item, nil
+	}
+}
+
+func TestFilteredItemsAreNotDroppedOrRetried(t *testing.T) {
+	var calls atomic.Uint64
+	sim := testkit.NewPipeline(t, testkit.PipelineOptions{Workers: 1, BufferSize: 4})
+	worker := sim.GetStages()[1]
+	worker.Config.RetryCount = 3
+	worker.Config.WorkerFunc = everyOther(&calls)
+	testkit.RunFor(t, sim, 50*time.Millisecond)
+
+	c := worker.GetCounts()
+	if c.Filtered == 0 {
+		t.Fatal("Expected filtered items")
+	}
+	if c.Dropped != 0 {
+		t.Errorf("Expected no drops, got %d", c.Dropped)
+	}
+	if got := calls.Load(); got != c.Received {
+		t.Errorf("Expected one call per received item, got %d calls for %d items", got, c.Received)
+	}
+	if c.Filtered+c.Processed != c.Received {
+		t.Errorf("Expected filtered + processed = %d, got %d + %d", c.Received, c.Filtered, c.Processed)
+	}
+	testkit.AssertAccounting(t, sim)
+}
+
+func TestStepReportsFilteredItems(t *testing.T) {
+	var calls atomic.Uint64
+	sim := testkit.NewPipeline(t, testkit.PipelineOptions{Workers: 1})
+	sim.GetStages()[1].Config.WorkerFunc = everyOther(&calls)
+	if err := sim.StartStepping(); err != nil {
+		t.Fatalf("Failed to start stepping: %v", err)
+	}
+
+	var kinds []simulator.EventKind
+	for range 8 {
+		event, err := sim.Step()
+		if err != nil {
+			t.Fatalf("Failed to step: %v", err)
+		}
+		if event.Stage == "Stage-1" {
+			kinds = append(kinds, event.Kind)
+		}
+	}
+
+	if len(kinds) < 2 || kinds[0] != simulator.EventForwarded || kinds[1] != simulator.EventFiltered {
+		t.Errorf("Expected forwarded then filtered, got %v", kinds)
+	}
+
+	if err := sim.StopStepping(simulator.Nothing); err != nil {
+		t.Fatalf("Failed to stop stepping: %v", err)
+	}
+	testkit.AssertAccounting(t, sim)
+}
+
+// panicEveryThird is an item generator panicking on every third call.
+func panicEveryThird() func() any {
+	var calls atomic.Uint64
+	return func() any {
+		if calls.Add(1)%3 == 0 {
+			panic("generator failed")
+		}
+		return 1
+	}
+}
+
+func TestGeneratorPanicsAreGeneratedAndDropped(t *testing.T) {
+	sim := testkit.NewPipeline(t, testkit.PipelineOptions{Workers: 1, BufferSize: 4})
+	generator := sim.GetStages()[0]
+	generator.Config.ItemGenerator = panicEveryThird()
+	testkit.RunFor(t, sim, 50*time.Millisecond)
+
+	c := generator.GetCounts()
+	if c.Dropped == 0 {
+		t.Fatal("Expected the panics to drop items")
+	}
+	if c.Generated < c.Dropped {
+		t.Errorf("Expected the %d dropped items among the %d generated", c.Dropped, c.Generated)
+	}
+
+	for _, v := range sim.CheckInvariants() {
+		if v.Severity == simulator.SeverityError {
+			t.Errorf("Expected no errors, got %s", v)
+		}
+	}
+}
+
+func TestStepGeneratorPanicsAreGeneratedAndDropped(t *testing.T) {
+	sim := testkit.NewPipeline(t, testkit.PipelineOptions{Workers: 1, BufferSize: 4})
+	sim.GetStages()[0].Config.ItemGenerator = panicEveryThird()
+	if err := sim.StartStepping(); err != nil {
+		t.Fatalf("Failed to start stepping: %v", err)
+	}
+
+	if _, err := sim.StepUntil(func(event simulator.Event) bool {
+		return event.Stage == "Generator" && event.Kind == simulator.EventDropped
+	}, 100); err != nil {
+		t.Fatalf("Expected a panicking generator step, got %v", err)
+	}
+
+	if err := sim.StopStepping(simulator.Nothing); err != nil {
+		t.Fatalf("Failed to stop stepping: %v", err)
+	}
+
+	for _, v := range sim.CheckInvariants() {
+		if v.Severity == simulator.SeverityError {
+			t.Errorf("Expected no errors, got %s", v)
+		}
+	}
+}
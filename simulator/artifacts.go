@@ -0,0 +1,139 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// artifactTask writes a single output file, no two tasks may target the
+// same file.
+type artifactTask func() error
+
+// writeArtifacts writes the pipeline graph and every worker stage's
+// blocked time histogram in parallel, bounded by ArtifactWorkers.
+// Every task runs even if others fail, their errors are joined.
+func (s *Simulator) writeArtifacts(filename string) error {
+	return s.writeArtifactsWith(filename, s.writePipelineGraph, s.writeGoroutineStats)
+}
+
+// writeArtifactsWith is writeArtifacts with the file writers passed in.
+// Nothing is written if two files would share a name.
+func (s *Simulator) writeArtifactsWith(filename string, writeGraph func(string) error, writeHistogram func(*Stage) error) error {
+	stages := s.histogramStages()
+	if err := checkArtifactNames(filename, stages); err != nil {
+		return err
+	}
+
+	tasks := make([]artifactTask, 0, len(stages)+1)
+	tasks = append(tasks, func() error {
+		return writeGraph(filename)
+	})
+	for _, stage := range stages {
+		tasks = append(tasks, func() error {
+			return writeHistogram(stage)
+		})
+	}
+
+	return runArtifactTasks(tasks, s.artifactWorkers())
+}
+
+// histogramStages returns the stages that get a blocked time histogram,
+// the worker stages of a tracked run.
+func (s *Simulator) histogramStages() []*Stage {
+	stages := s.GetStages()
+	if len(stages) < 3 || s.Profile == FastProfile || s.stepper != nil {
+		return nil
+	}
+	return stages[1 : len(stages)-1]
+}
+
+// histogramFilename is the file the tracker writes a stage's histogram
+// to, relative to the working directory.
+func histogramFilename(stageName string) string {
+	return strings.ReplaceAll(stageName+".dot", " ", "_")
+}
+
+// checkArtifactNames fails if a histogram would overwrite the graph or
+// another histogram. Stage names are unique, but "A B" and "A_B" map to
+// the same file, as does a stage named "pipeline" with "pipeline.dot".
+func checkArtifactNames(filename string, stages []*Stage) error {
+	owners := make(map[string]string, len(stages)+1)
+	owners[artifactPath(filename)] = "the pipeline graph"
+
+	for _, stage := range stages {
+		name := histogramFilename(stage.Name)
+		path := artifactPath(name)
+		if owner, ok := owners[path]; ok {
+			return fmt.Errorf("histogram of stage %q would overwrite %s in %s, rename the stage", stage.Name, owner, name)
+		}
+		owners[path] = fmt.Sprintf("the histogram of stage %q", stage.Name)
+	}
+
+	return nil
+}
+
+func artifactPath(name string) string {
+	if path, err := filepath.Abs(name); err == nil {
+		return path
+	}
+	return filepath.Clean(name)
+}
+
+func (s *Simulator) artifactWorkers() int {
+	if s.ArtifactWorkers > 0 {
+		return s.ArtifactWorkers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+func runArtifactTasks(tasks []artifactTask, workers int) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+
+	queue := make(chan artifactTask)
+	for range min(workers, len(tasks)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				if err := task(); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, task := range tasks {
+		queue <- task
+	}
+	close(queue)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// writePipelineGraph writes only the pipeline DOT graph to filename.
+func (s *Simulator) writePipelineGraph(filename string) (err error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	return s.WritePipelineDotTo(f)
+}
@@ -0,0 +1,125 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteArtifactsRunsEveryTask(t *testing.T) {
+	const workers = 36
+	sim := NewSimulator()
+	sim.ArtifactWorkers = 4
+
+	names := []string{"Generator"}
+	for i := range workers {
+		names = append(names, fmt.Sprintf("Stage-%d", i+1))
+	}
+	names = append(names, "Sink")
+	for _, name := range names {
+		if err := sim.AddStage(NewStage(name, &StageConfig{})); err != nil {
+			t.Fatalf("Failed to add stage %s: %v", name, err)
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		written = make(map[string]int)
+	)
+	errBoom := errors.New("boom")
+
+	writeGraph := func(filename string) error {
+		mu.Lock()
+		written[filename]++
+		mu.Unlock()
+		return nil
+	}
+	writeHistogram := func(stage *Stage) error {
+		mu.Lock()
+		written[stage.Name]++
+		mu.Unlock()
+		if stage.Name == "Stage-7" {
+			return errBoom
+		}
+		return nil
+	}
+
+	err := sim.writeArtifactsWith("pipeline.dot", writeGraph, writeHistogram)
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("Expected the failing writer's error, got %v", err)
+	}
+
+	if len(written) != workers+1 {
+		t.Errorf("Expected %d files, got %d", workers+1, len(written))
+	}
+	for name, n := range written {
+		if n != 1 {
+			t.Errorf("Expected %s to be written once, got %d", name, n)
+		}
+	}
+	for _, name := range []string{"Generator", "Sink"} {
+		if written[name] != 0 {
+			t.Errorf("Expected no histogram for %s", name)
+		}
+	}
+}
+
+func TestWriteArtifactsRejectsCollisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		stages   []string
+		filename string
+		want     string
+	}{
+		{"sanitized names", []string{"A B", "A_B"}, "pipeline.dot", `stage "A_B"`},
+		{"graph file", []string{"Parse", "pipeline"}, "pipeline.dot", "the pipeline graph"},
+		{"graph file with spaces", []string{"my pipeline"}, "./my_pipeline.dot", "the pipeline graph"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := NewSimulator()
+			names := append(append([]string{"Generator"}, tt.stages...), "Sink")
+			for _, name := range names {
+				if err := sim.AddStage(NewStage(name, &StageConfig{})); err != nil {
+					t.Fatalf("Failed to add stage %s: %v", name, err)
+				}
+			}
+
+			calls := 0
+			write := func(string) error { calls++; return nil }
+			writeHistogram := func(*Stage) error { calls++; return nil }
+
+			err := sim.writeArtifactsWith(tt.filename, write, writeHistogram)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Expected a collision error naming %s, got %v", tt.want, err)
+			}
+			if calls != 0 {
+				t.Errorf("Expected nothing written, got %d writes", calls)
+			}
+		})
+	}
+}
+
+func TestWriteArtifactsFastProfileSkipsHistograms(t *testing.T) {
+	sim := NewSimulator()
+	sim.Profile = FastProfile
+	for _, name := range []string{"Generator", "pipeline", "Sink"} {
+		if err := sim.AddStage(NewStage(name, &StageConfig{})); err != nil {
+			t.Fatalf("Failed to add stage %s: %v", name, err)
+		}
+	}
+
+	histograms := 0
+	err := sim.writeArtifactsWith("pipeline.dot",
+		func(string) error { return nil },
+		func(*Stage) error { histograms++; return nil })
+	if err != nil {
+		t.Fatalf("Expected no collision without histograms, got %v", err)
+	}
+	if histograms != 0 {
+		t.Errorf("Expected no histograms, got %d", histograms)
+	}
+}
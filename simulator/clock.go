@@ -0,0 +1,98 @@
+package simulator
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of time for the simulator and its stages, every
+// sleep, timer and timestamp goes through it so tests can swap in a fake.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the part of time.Ticker the simulator uses.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// RealClock is the default Clock, backed by the time package.
+type RealClock struct{}
+
+// Now returns time.Now.
+func (RealClock) Now() time.Time { return time.Now() }
+
+// Sleep calls time.Sleep.
+func (RealClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// After calls time.After.
+func (RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// NewTicker wraps time.NewTicker.
+func (RealClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (r realTicker) C() <-chan time.Time { return r.t.C }
+
+func (r realTicker) Stop() { r.t.Stop() }
+
+// coarseClock is a timestamp refreshed by a background ticker, reading it
+// costs an atomic load instead of a Now call, at the price of only being
+// accurate to its resolution.
+type coarseClock struct {
+	now      atomic.Int64
+	ticker   Ticker
+	quit     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// newCoarseClock starts the ticker goroutine with spawn.
+func newCoarseClock(clock Clock, resolution time.Duration, spawn func(func())) *coarseClock {
+	c := &coarseClock{
+		ticker:  clock.NewTicker(resolution),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	c.now.Store(clock.Now().UnixNano())
+
+	spawn(c.run)
+
+	return c
+}
+
+func (c *coarseClock) run() {
+	defer close(c.stopped)
+
+	for {
+		select {
+		case <-c.quit:
+			return
+		case t := <-c.ticker.C():
+			c.now.Store(t.UnixNano())
+		}
+	}
+}
+
+// Now returns the last refreshed timestamp.
+func (c *coarseClock) Now() time.Time {
+	return time.Unix(0, c.now.Load())
+}
+
+// stop releases the ticker goroutine and waits for it to return, it's
+// safe to call more than once.
+func (c *coarseClock) stop() {
+	c.stopOnce.Do(func() {
+		c.ticker.Stop()
+		close(c.quit)
+	})
+	<-c.stopped
+}
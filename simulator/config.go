@@ -1,17 +1,19 @@
 package simulator
 
-import (
-	"context"
-	"time"
-)
+import "time"
 
 // StageConfig holds the configuration for a pipeline stage,
 // it can be shared among all pipelines.
 type StageConfig struct {
 
-	// Rate at which items are generated (generator only)
+	// Rate at which items are generated (generator only), shared by all
+	// the stage's goroutines.
 	InputRate time.Duration
 
+	// Apply InputRate to each generator goroutine instead of the whole
+	// stage, making the offered load RoutineNum / InputRate (generator only)
+	PerWorkerRate bool
+
 	// Custom item generator function  (generator only)
 	ItemGenerator func() any
 
@@ -21,22 +23,59 @@ type StageConfig struct {
 	// Channel buffer size per stage
 	BufferSize int
 
+	// Start with CoreWorkers goroutines and spawn more, up to RoutineNum,
+	// only while the input buffer stays above SpawnThreshold. The previous
+	// stage must set BufferSize, Start fails otherwise (non-generator only)
+	LazyWorkers bool
+
+	// Goroutines started up front in lazy mode, defaults to 1.
+	CoreWorkers int
+
+	// Input occupancy (0-1) that, seen on two consecutive checks, spawns
+	// another worker in lazy mode. Defaults to 0.75.
+	SpawnThreshold float64
+
+	// How often the input occupancy is checked in lazy mode, defaults
+	// to 10ms.
+	SpawnInterval time.Duration
+
 	// Simulated delay per item
 	WorkerDelay time.Duration
 
 	// Number of times to retry on error, since your custom function
-	// could fail.
+	// could fail. The first call isn't a retry, so an item gets at most
+	// RetryCount + 1 calls and zero never retries.
 	RetryCount int
 
 	// Drop input if channel is full, when not set to drop it will block
 	// in case the channels are full.
 	DropOnBackpressure bool
 
-	// Custom worker function that processes each item
+	// Custom worker function that processes each item, it returns
+	// ErrFiltered to filter the item out.
 	WorkerFunc func(item any) (any, error)
 
-	// Context for cancellation and deadlines
-	ctx context.Context
+	// Resolution of the coarse clock used to time select-case waits,
+	// zero calls time.Now on every wait. Waits shorter than the
+	// resolution may be recorded as zero.
+	TrackingResolution time.Duration
+
+	// Record only every Nth select-case wait. The recorded latencies are
+	// real, the hit counts and blocked time totals are scaled back up by
+	// N. Zero or one records every wait.
+	TrackingSampleRate int
+
+	// Maximum select-case waits each goroutine forwards to the tracker,
+	// bounding its memory on long runs. Once reached, newer waits still
+	// count towards the blocked time totals and hits but their latencies
+	// aren't kept for the percentiles, they're counted as tracking
+	// evictions. Zero means unbounded.
+	TrackingMaxRecords int
+
+	// Give each goroutine its own padded metric counters, summed on
+	// read, to avoid atomic contention at high routine counts. Costs a
+	// cache line per goroutine, so the shared counters are the default.
+	ShardedMetrics bool
 }
 
 // DefaultConfig returns a new SimulationConfig with sensible defaults
@@ -0,0 +1,97 @@
+package simulator
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// countingWriter counts what it's written and keeps the first write, to
+// check the DOT output reaches it while the graph is still generated.
+type countingWriter struct {
+	written int
+	writes  int
+	largest int
+	first   string
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.writes == 0 {
+		w.first = string(p)
+	}
+	w.written += len(p)
+	w.writes++
+	w.largest = max(w.largest, len(p))
+
+	return len(p), nil
+}
+
+// TestWritePipelineDotToStreamsLargePipelines writes a 500-stage graph
+// and checks the output reached the writer in bounded chunks, the first
+// one before the last node was generated.
+func TestWritePipelineDotToStreamsLargePipelines(t *testing.T) {
+	const stages = 500
+
+	sim := NewSimulator()
+	for i := range stages {
+		stage := fixtureStage(fmt.Sprintf("Stage-%d", i), fixtureCounts{received: 1000, processed: 900, output: 800, dropped: 100}, 10)
+		if err := sim.AddStage(stage); err != nil {
+			t.Fatalf("Failed to add stage: %v", err)
+		}
+	}
+	sim.stages[0].isGenerator = true
+	sim.stages[stages-1].isFinal = true
+
+	w := &countingWriter{}
+	if err := sim.WritePipelineDotTo(w); err != nil {
+		t.Fatalf("Failed to write the DOT graph: %v", err)
+	}
+
+	// A buffered graph would arrive in a single write, a streamed one in
+	// writes no larger than the bufio buffer.
+	const chunk = 4096
+	if w.written < 10*chunk {
+		t.Fatalf("Expected at least %d bytes of output, got %d", 10*chunk, w.written)
+	}
+
+	if w.largest > chunk {
+		t.Errorf("Expected writes of at most %d bytes, got one of %d", chunk, w.largest)
+	}
+
+	if w.writes < w.written/chunk {
+		t.Errorf("Expected at least %d writes for %d bytes, got %d", w.written/chunk, w.written, w.writes)
+	}
+
+	if !strings.HasPrefix(w.first, "digraph Pipeline {") {
+		t.Errorf("Expected the first write to start the graph, got %.40q", w.first)
+	}
+
+	if last := fmt.Sprintf("stage_%d [", stages-1); strings.Contains(w.first, last) {
+		t.Errorf("Expected the first write before the last node was generated, it holds %s", last)
+	}
+}
+
+func TestWritePipelineDotToGraph(t *testing.T) {
+	sim := NewSimulator()
+	for _, stage := range fixturePipeline() {
+		if err := sim.AddStage(stage); err != nil {
+			t.Fatalf("Failed to add stage: %v", err)
+		}
+	}
+
+	var b strings.Builder
+	if err := sim.WritePipelineDotTo(&b); err != nil {
+		t.Fatalf("Failed to write the DOT graph: %v", err)
+	}
+
+	out := b.String()
+	if !strings.HasPrefix(out, "digraph Pipeline {") || !strings.HasSuffix(strings.TrimSpace(out), "}") {
+		t.Errorf("Expected a complete digraph, got:\n%s", out)
+	}
+
+	for i := range 4 {
+		if edge := fmt.Sprintf("stage_%d -> stage_%d;", i, i+1); !strings.Contains(out, edge) {
+			t.Errorf("Expected edge %q in the graph", edge)
+		}
+	}
+}
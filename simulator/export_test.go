@@ -0,0 +1,45 @@
+package simulator
+
+import (
+	"time"
+
+	"github.com/AlexsanderHamir/IdleSpy/tracker"
+)
+
+// The package's internals that the external tests inspect.
+
+// OutputCaseLabel is the select case of a stage's backpressure waits.
+var OutputCaseLabel = outputCaseLabel
+
+// SetCleanGrace changes how long VerifyClean waits and returns a func
+// restoring it.
+func SetCleanGrace(d time.Duration) (restore func()) {
+	previous := cleanGrace
+	cleanGrace = d
+	return func() { cleanGrace = previous }
+}
+
+// Tracker returns the stage's goroutine tracker.
+func (s *Stage) Tracker() *tracker.GoroutineManager {
+	return s.gm
+}
+
+// WorkersStarted returns the stage's high-water worker count.
+func (s *Stage) WorkersStarted() int64 {
+	return s.workersStarted.Load()
+}
+
+// SpawnEvents returns how many workers lazy mode added.
+func (s *Stage) SpawnEvents() uint64 {
+	return s.spawnEvents.Load()
+}
+
+// Active returns the stage's goroutines that haven't released it.
+func (s *Stage) Active() int64 {
+	return s.active.Load()
+}
+
+// Spawn starts fn on a goroutine the stage accounts for.
+func (s *Stage) Spawn(fn func()) {
+	s.spawn(fn)
+}
@@ -0,0 +1,70 @@
+package simulator_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator"
+	"github.com/AlexsanderHamir/GoFlow/simulator/testkit"
+	"github.com/AlexsanderHamir/GoFlow/simulator/testutil"
+)
+
+func TestGenerationRateIndependentOfRoutines(t *testing.T) {
+	const (
+		rate  = time.Millisecond
+		ticks = 50
+	)
+
+	for _, routines := range []int{1, 10, 100} {
+		t.Run(fmt.Sprintf("routines=%d", routines), func(t *testing.T) {
+			sim := testkit.NewPipeline(t, testkit.PipelineOptions{
+				Workers:    1,
+				RoutineNum: routines,
+				BufferSize: ticks,
+				InputRate:  rate,
+			})
+			clock := testutil.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+			sim.Clock = clock
+			sim.Duration = ticks*rate + rate/2
+			generator := sim.GetStages()[0]
+
+			done := make(chan error, 1)
+			go func() { done <- sim.Start(simulator.Nothing) }()
+
+			// The shared pacer and the run's timer.
+			clock.BlockUntil(2)
+
+			// Every tick is taken before the next one, a ticker drops the
+			// ticks nobody is waiting for.
+			for i := range ticks {
+				clock.Advance(rate)
+				waitFor(t, func() bool { return generator.GetCounts().Generated == uint64(i+1) })
+			}
+			clock.Advance(rate / 2)
+
+			if err := <-done; err != nil {
+				t.Fatalf("Failed to run the simulation: %v", err)
+			}
+
+			if got := generator.GetCounts().Generated; got != ticks {
+				t.Errorf("Expected exactly %d generated items, got %d", ticks, got)
+			}
+			testkit.AssertAccounting(t, sim)
+			testkit.AssertClean(t, sim)
+		})
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the condition")
+		}
+		time.Sleep(50 * time.Microsecond)
+	}
+}
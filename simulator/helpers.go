@@ -1,7 +1,9 @@
 package simulator
 
 import (
+	"bufio"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/AlexsanderHamir/IdleSpy/tracker"
@@ -15,6 +17,11 @@ type stageStats struct {
 	DroppedItems   uint64
 	DropRate       float64
 	GeneratedItems uint64
+	GenerationRate float64
+	OfferedRate    float64
+	ConsumedItems  uint64
+	InFlightItems  uint64
+	FilteredItems  uint64
 	ThruDiffPct    float64
 	ProcDiffPct    float64
 	isGenerator    bool
@@ -23,14 +30,20 @@ type stageStats struct {
 
 func collectStageStats(stage *Stage) stageStats {
 	stats := stage.GetMetrics().GetStats()
+	generationRate, _ := stats["generation_rate"].(float64)
 	return stageStats{
 		StageName:      stage.Name,
-		ProcessedItems: stage.metrics.processedItems,
-		OutputItems:    stage.metrics.outputItems,
+		ProcessedItems: stage.metrics.loadProcessed(),
+		OutputItems:    stage.metrics.loadOutput(),
 		Throughput:     stats["throughput"].(float64),
-		DroppedItems:   stage.metrics.droppedItems,
+		DroppedItems:   stage.metrics.loadDropped(),
 		DropRate:       stats["drop_rate"].(float64),
-		GeneratedItems: stage.metrics.generatedItems,
+		GeneratedItems: stage.metrics.loadGenerated(),
+		GenerationRate: generationRate,
+		OfferedRate:    stage.offeredRate(),
+		ConsumedItems:  stage.metrics.loadConsumed(),
+		InFlightItems:  stage.metrics.loadInFlight(),
+		FilteredItems:  stage.metrics.loadFiltered(),
 		isGenerator:    stage.isGenerator,
 		IsFinal:        stage.isFinal,
 	}
@@ -62,14 +75,154 @@ func computeDiffs(prev, curr *stageStats) (procDiffStr, thruDiffStr string) {
 	return procDiffStr, thruDiffStr
 }
 
-func printHeader() {
-	fmt.Printf("\n%-20s %12s %12s %12s %12s %12s %12s %12s\n",
-		"Stage", "Processed", "Output", "Throughput", "Dropped", "Drop Rate %", "Proc Δ%", "Thru Δ%")
-	fmt.Println(strings.Repeat("-", 114))
+// statsRow is a single line of the stats table, it keeps the pipeline
+// index so the position isn't lost once the rows are sorted.
+type statsRow struct {
+	index    int
+	stats    stageStats
+	procDiff string
+	thruDiff string
 }
 
-func printStageRow(stat *stageStats, procDiff, thruDiff string) {
-	fmt.Printf("%-20s %12d %12d %12.2f %12d %12.2f %12s %12s\n",
+// collectStatsRows gathers the stats of every stage in pipeline order.
+// The diffs are computed against the pipeline neighbor, or against the
+// baseline stage for every row when one is given.
+func collectStatsRows(stages []*Stage, baseline string) []*statsRow {
+	rows := make([]*statsRow, 0, len(stages))
+	var base *stageStats
+
+	for i, stage := range stages {
+		row := &statsRow{index: i, stats: collectStageStats(stage)}
+		if stage.Name == baseline {
+			base = &row.stats
+		}
+		rows = append(rows, row)
+	}
+
+	var prev *stageStats
+	for _, row := range rows {
+		row.procDiff, row.thruDiff = rowDiffs(&row.stats, prev, base)
+		prev = &row.stats
+	}
+
+	return rows
+}
+
+// rowDiffs computes a row's diffs against its predecessor prev, or
+// against base when a baseline is used.
+func rowDiffs(stats, prev, base *stageStats) (procDiff, thruDiff string) {
+	switch {
+	case base == nil:
+		return computeDiffs(prev, stats)
+	case stats.StageName == base.StageName:
+		return "-", "-"
+	default:
+		return computeDiffs(base, stats)
+	}
+}
+
+// validateBaseline checks that the baseline names one of the worker
+// stages, the generator and the sink have no comparable numbers.
+func validateBaseline(stages []*Stage, baseline string) error {
+	if baseline == "" {
+		return nil
+	}
+
+	names := make([]string, 0, len(stages))
+	for i, stage := range stages {
+		isWorker := i != 0 && i != len(stages)-1
+		if stage.Name == baseline {
+			if !isWorker {
+				return fmt.Errorf("baseline stage %q must be a worker stage, not the generator or the sink", baseline)
+			}
+			return nil
+		}
+
+		if isWorker {
+			names = append(names, stage.Name)
+		}
+	}
+
+	return fmt.Errorf("unknown baseline stage %q, available stages: %s", baseline, strings.Join(names, ", "))
+}
+
+var validSortKeys = []StatsSortKey{
+	SortByProcessed,
+	SortByOutput,
+	SortByThroughput,
+	SortByDropped,
+	SortByDropRate,
+}
+
+func validateSortKey(key StatsSortKey) error {
+	if key == SortByPipeline {
+		return nil
+	}
+
+	names := make([]string, 0, len(validSortKeys))
+	for _, valid := range validSortKeys {
+		if key == valid {
+			return nil
+		}
+		names = append(names, string(valid))
+	}
+
+	return fmt.Errorf("invalid sort key %q, valid keys: %s", key, strings.Join(names, ", "))
+}
+
+// sortKeyValue returns the numeric column selected by key.
+func sortKeyValue(row *statsRow, key StatsSortKey) float64 {
+	switch key {
+	case SortByProcessed:
+		return float64(row.stats.ProcessedItems)
+	case SortByOutput:
+		return float64(row.stats.OutputItems)
+	case SortByThroughput:
+		return row.stats.Throughput
+	case SortByDropped:
+		return float64(row.stats.DroppedItems)
+	case SortByDropRate:
+		return row.stats.DropRate
+	default:
+		return float64(row.index)
+	}
+}
+
+// sortStatsRows sorts the rows by the given key, ties fall back to
+// pipeline order so the output is deterministic.
+func sortStatsRows(rows []*statsRow, key StatsSortKey, desc bool) {
+	if key == SortByPipeline {
+		return
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := sortKeyValue(rows[i], key), sortKeyValue(rows[j], key)
+		if a == b {
+			return rows[i].index < rows[j].index
+		}
+
+		if desc {
+			return a > b
+		}
+		return a < b
+	})
+}
+
+func printHeader(profile Profile, baseline string) {
+	fmt.Printf("\nProfile: %s\n", profile)
+
+	if baseline != "" {
+		fmt.Printf("\nΔ%% columns compared against baseline stage %q\n", baseline)
+	}
+
+	fmt.Printf("\n%4s %-20s %12s %12s %12s %12s %12s %12s %12s\n",
+		"#", "Stage", "Processed", "Output", "Throughput", "Dropped", "Drop Rate %", "Proc Δ%", "Thru Δ%")
+	fmt.Println(strings.Repeat("-", 119))
+}
+
+func printStageRow(index int, stat *stageStats, procDiff, thruDiff string) {
+	fmt.Printf("%4d %-20s %12d %12d %12.2f %12d %12.2f %12s %12s\n",
+		index,
 		stat.StageName,
 		stat.ProcessedItems,
 		stat.OutputItems,
@@ -81,36 +234,76 @@ func printStageRow(stat *stageStats, procDiff, thruDiff string) {
 	)
 }
 
-func (s *Simulator) writeDotHeader(b *strings.Builder) {
+// printGenerationRate shows the achieved aggregate generation rate next
+// to the configured one, so runs with different RoutineNum can be compared.
+func printGenerationRate(stat *stageStats) {
+	if stat.OfferedRate > 0 {
+		fmt.Printf("\n%s: generated %.2f items/s (configured %.2f items/s)\n",
+			stat.StageName, stat.GenerationRate, stat.OfferedRate)
+		return
+	}
+
+	fmt.Printf("\n%s: generated %.2f items/s (unpaced)\n", stat.StageName, stat.GenerationRate)
+}
+
+// printFlowSummary shows the items the stages filtered, the ones the
+// sink consumed and the ones left in flight when the simulation was
+// stopped, none of them is in the Dropped column.
+func printFlowSummary(rows []*statsRow) {
+	var inFlight uint64
+	for _, row := range rows {
+		inFlight += row.stats.InFlightItems
+		if row.stats.FilteredItems > 0 {
+			fmt.Printf("\n%s: filtered %d items, not counted as dropped\n", row.stats.StageName, row.stats.FilteredItems)
+		}
+		if row.stats.IsFinal {
+			fmt.Printf("\n%s: consumed %d items, not counted as dropped\n", row.stats.StageName, row.stats.ConsumedItems)
+		}
+	}
+
+	if inFlight > 0 {
+		fmt.Printf("%d items were in flight when the simulation stopped, not counted as dropped\n", inFlight)
+	}
+}
+
+func (s *Simulator) writeDotHeader(b *bufio.Writer) {
 	b.WriteString("digraph Pipeline {\n")
 	b.WriteString("  rankdir=LR;\n")
+	label := fmt.Sprintf("Profile: %s", s.Profile)
+	if s.Baseline != "" {
+		label += fmt.Sprintf(", Δ%% compared against baseline stage %s", s.Baseline)
+	}
+	fmt.Fprintf(b, "  label=\"%s\";\n", label)
 	b.WriteString("  node [shape=box, style=filled, fontname=\"Arial\", fontsize=10];\n")
 	b.WriteString("  edge [fontname=\"Arial\", fontsize=8];\n\n")
 }
 
-func (s *Simulator) writeDotNodes(b *strings.Builder) error {
-	var prevStats *stageStats
+// writeDotNodes computes every stage's stats as its node is written,
+// only the previous stage's and the baseline's are kept.
+func (s *Simulator) writeDotNodes(b *bufio.Writer) {
 	stages := s.GetStages()
-	first, last := 0, len(stages)-1
 
+	var base *stageStats
+	for _, stage := range stages {
+		if stage.Name == s.Baseline {
+			stats := collectStageStats(stage)
+			base = &stats
+		}
+	}
+
+	var prev *stageStats
 	for i, stage := range stages {
-		currentStats := collectStageStats(stage)
-		procDiffStr, thruDiffStr := computeDiffs(prevStats, &currentStats)
-		prevStats = &currentStats
+		stats := collectStageStats(stage)
+		procDiff, thruDiff := rowDiffs(&stats, prev, base)
 
 		nodeColor := s.getNodeColor(stage)
-		label := s.formatNodeLabel(stage, &currentStats, procDiffStr, thruDiffStr)
+		label := s.formatNodeLabel(stage, &stats, procDiff, thruDiff)
 
 		fmt.Fprintf(b, "  stage_%d [label=%s, style=filled, fillcolor=%s];\n",
 			i, label, nodeColor)
 
-		if i != first && i != last {
-			if err := s.writeGoroutineStats(stage); err != nil {
-				return err
-			}
-		}
+		prev = &stats
 	}
-	return nil
 }
 
 func (s *Simulator) getNodeColor(stage *Stage) string {
@@ -125,14 +318,38 @@ func (s *Simulator) getNodeColor(stage *Stage) string {
 }
 
 func (s *Simulator) formatNodeLabel(stage *Stage, stats *stageStats, procDiff, thruDiff string) string {
-	return fmt.Sprintf(`"%s\nRoutines: %d\nBuffer: %d\nProcessed: %d (%s)\nDroppedItems: %d\nOutput: %d\nThroughput: %.2f (%s)"`,
+	var extra string
+	if stage.isGenerator {
+		extra += fmt.Sprintf(`\nGenerated: %d (%.2f/s)`, stats.GeneratedItems, stats.GenerationRate)
+	}
+
+	if stage.isFinal {
+		extra += fmt.Sprintf(`\nConsumed: %d`, stats.ConsumedItems)
+	}
+
+	if stats.FilteredItems > 0 {
+		extra += fmt.Sprintf(`\nFiltered: %d`, stats.FilteredItems)
+	}
+
+	if stats.InFlightItems > 0 {
+		extra += fmt.Sprintf(`\nIn flight at stop: %d`, stats.InFlightItems)
+	}
+
+	if stage.stepping {
+		extra += `\nBlocked time: n/a (step mode)`
+	} else if note := stage.trackingNote(); note != "" {
+		extra += fmt.Sprintf(`\nBlocked time: %s`, note)
+	}
+
+	return fmt.Sprintf(`"%s\nRoutines: %s\nBuffer: %d\nProcessed: %d (%s)\nDroppedItems: %d\nOutput: %d\nThroughput: %.2f (%s)%s"`,
 		stage.Name,
-		stage.Config.RoutineNum,
+		stage.routinesLabel(),
 		stage.Config.BufferSize,
 		stats.ProcessedItems, procDiff,
 		stats.DroppedItems,
 		stats.OutputItems,
 		stats.Throughput, thruDiff,
+		extra,
 	)
 }
 
@@ -145,7 +362,7 @@ func (s *Simulator) writeGoroutineStats(stage *Stage) error {
 	return nil
 }
 
-func (s *Simulator) writeDotEdges(b *strings.Builder) {
+func (s *Simulator) writeDotEdges(b *bufio.Writer) {
 	b.WriteString("\n")
 	stages := s.GetStages()
 	for i := 0; i < len(stages)-1; i++ {
@@ -153,6 +370,6 @@ func (s *Simulator) writeDotEdges(b *strings.Builder) {
 	}
 }
 
-func (s *Simulator) writeDotFooter(b *strings.Builder) {
+func (s *Simulator) writeDotFooter(b *bufio.Writer) {
 	b.WriteString("}\n")
 }
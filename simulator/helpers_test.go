@@ -0,0 +1,151 @@
+package simulator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStatsTableSortGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		key    StatsSortKey
+		desc   bool
+	}{
+		{"sort_pipeline.golden", SortByPipeline, false},
+		{"sort_processed_desc.golden", SortByProcessed, true},
+		{"sort_output.golden", SortByOutput, false},
+		{"sort_throughput_desc.golden", SortByThroughput, true},
+		{"sort_dropped.golden", SortByDropped, false},
+		{"sort_drop_rate_desc.golden", SortByDropRate, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			s := &Simulator{SortBy: tt.key, SortDesc: tt.desc}
+			out := captureStdout(t, func() {
+				s.printStatsTable(fixturePipeline())
+			})
+
+			checkGolden(t, tt.golden, out)
+		})
+	}
+}
+
+func TestSortStatsRowsTiesKeepPipelineOrder(t *testing.T) {
+	stages := fixturePipeline()
+
+	for _, desc := range []bool{false, true} {
+		rows := collectStatsRows(stages, "")
+		sortStatsRows(rows, SortByProcessed, desc)
+
+		// Parse and Enrich both processed 800 items.
+		var tied []string
+		for _, row := range rows {
+			if row.stats.ProcessedItems == 800 {
+				tied = append(tied, row.stats.StageName)
+			}
+		}
+
+		if got := strings.Join(tied, ","); got != "Parse,Enrich" {
+			t.Errorf("Expected ties in pipeline order Parse,Enrich (desc=%v), got %s", desc, got)
+		}
+	}
+}
+
+func TestValidateSortKey(t *testing.T) {
+	for _, key := range append([]StatsSortKey{SortByPipeline}, validSortKeys...) {
+		if err := validateSortKey(key); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", key, err)
+		}
+	}
+
+	err := validateSortKey("latency")
+	if err == nil {
+		t.Fatal("Expected an error for an unknown sort key")
+	}
+
+	if !strings.Contains(err.Error(), "drop-rate") {
+		t.Errorf("Expected the error to list the valid keys, got %v", err)
+	}
+}
+
+func TestCollectStatsRowsDiffModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseline string
+		want     map[string][2]string
+	}{
+		{
+			// Each worker is compared to its predecessor, the first worker
+			// follows the generator so it has nothing to compare against.
+			name: "neighbor",
+			want: map[string][2]string{
+				"Generator": {"", ""},
+				"Parse":     {"", ""},
+				"Enrich":    {"+0.00", "-25.00"},
+				"Store":     {"-50.00", "+66.67"},
+				"Sink":      {"", ""},
+			},
+		},
+		{
+			// Every worker is compared to Parse: processed 800, 80 items/s.
+			name:     "baseline",
+			baseline: "Parse",
+			want: map[string][2]string{
+				"Generator": {"", ""},
+				"Parse":     {"-", "-"},
+				"Enrich":    {"+0.00", "-25.00"},
+				"Store":     {"-50.00", "+25.00"},
+				"Sink":      {"", ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, row := range collectStatsRows(fixturePipeline(), tt.baseline) {
+				want := tt.want[row.stats.StageName]
+				if row.procDiff != want[0] || row.thruDiff != want[1] {
+					t.Errorf("%s: expected proc %q thru %q, got proc %q thru %q",
+						row.stats.StageName, want[0], want[1], row.procDiff, row.thruDiff)
+				}
+			}
+		})
+	}
+}
+
+func TestStatsTableBaselineGolden(t *testing.T) {
+	s := &Simulator{Baseline: "Parse"}
+	out := captureStdout(t, func() {
+		s.printStatsTable(fixturePipeline())
+	})
+
+	checkGolden(t, "baseline_parse.golden", out)
+}
+
+func TestValidateBaseline(t *testing.T) {
+	stages := fixturePipeline()
+
+	if err := validateBaseline(stages, ""); err != nil {
+		t.Errorf("Expected no baseline to be valid, got %v", err)
+	}
+
+	if err := validateBaseline(stages, "Enrich"); err != nil {
+		t.Errorf("Expected a worker stage to be a valid baseline, got %v", err)
+	}
+
+	for _, name := range []string{"Generator", "Sink"} {
+		if err := validateBaseline(stages, name); err == nil {
+			t.Errorf("Expected %s to be rejected as a baseline", name)
+		}
+	}
+
+	err := validateBaseline(stages, "Missing")
+	if err == nil {
+		t.Fatal("Expected an unknown baseline to be rejected")
+	}
+
+	if !strings.Contains(err.Error(), "Parse, Enrich, Store") {
+		t.Errorf("Expected the error to list the worker stages, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package simulator
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// Severity ranks an invariant violation.
+type Severity int
+
+const (
+	// SeverityWarning marks numbers that look wrong but can't lose items.
+	SeverityWarning Severity = iota
+	// SeverityError marks broken accounting, items went missing or were
+	// counted twice.
+	SeverityError
+)
+
+// String returns "error" or "warning".
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// InvariantViolation is an accounting identity that didn't hold after a
+// run, Stage is empty for pipeline-wide checks.
+type InvariantViolation struct {
+	Stage    string
+	Identity string
+	Expected float64
+	Actual   float64
+	Severity Severity
+}
+
+// String formats the violation as
+// "severity: stage: identity (expected x, got y)".
+func (v InvariantViolation) String() string {
+	return fmt.Sprintf("%s: %s: %s (expected %g, got %g)", v.Severity, v.Stage, v.Identity, v.Expected, v.Actual)
+}
+
+// CheckInvariants audits the simulator's own numbers once the run has
+// finished:
+//   - generator: generated = output + dropped + in flight
+//   - other stages: received = output + dropped + filtered + consumed + in flight
+//   - between stages: output = next stage's received + items left buffered
+//   - throughput and drop rate are finite and non-negative
+//   - generators receive nothing, only sinks consume and sinks output nothing
+//
+// It returns nil when every identity holds.
+func (s *Simulator) CheckInvariants() []InvariantViolation {
+	select {
+	case <-s.done():
+	default:
+		return []InvariantViolation{{
+			Identity: "simulation finished",
+			Expected: 1,
+			Actual:   0,
+			Severity: SeverityError,
+		}}
+	}
+
+	var violations []InvariantViolation
+	stages := s.GetStages()
+	for i, stage := range stages {
+		violations = append(violations, stage.checkInvariants()...)
+
+		if i < len(stages)-1 {
+			violations = append(violations, checkFlow(stage, stages[i+1])...)
+		}
+	}
+
+	return violations
+}
+
+func (s *Stage) checkInvariants() []InvariantViolation {
+	var violations []InvariantViolation
+	violation := func(identity string, expected, actual uint64, severity Severity) {
+		if expected != actual {
+			violations = append(violations, InvariantViolation{
+				Stage:    s.Name,
+				Identity: identity,
+				Expected: float64(expected),
+				Actual:   float64(actual),
+				Severity: severity,
+			})
+		}
+	}
+
+	c := s.GetCounts()
+	if s.isGenerator {
+		violation("generated = output + dropped + in flight", c.Generated, c.Output+c.Dropped+c.InFlight, SeverityError)
+		violation("generator receives nothing", 0, c.Received, SeverityError)
+		violation("generator processes nothing", 0, c.Processed, SeverityWarning)
+	} else {
+		violation("received = output + dropped + filtered + consumed + in flight", c.Received, c.Output+c.Dropped+c.Filtered+c.Consumed+c.InFlight, SeverityError)
+		violation("only generators generate", 0, c.Generated, SeverityError)
+	}
+
+	if s.isFinal {
+		violation("sink outputs nothing", 0, c.Output, SeverityError)
+	} else {
+		violation("only sinks consume", 0, c.Consumed, SeverityError)
+	}
+
+	stats := collectStageStats(s)
+	for _, rate := range []struct {
+		name  string
+		value float64
+	}{
+		{"throughput >= 0", stats.Throughput},
+		{"drop rate >= 0", stats.DropRate},
+	} {
+		if rate.value < 0 || math.IsNaN(rate.value) || math.IsInf(rate.value, 0) {
+			violations = append(violations, InvariantViolation{
+				Stage:    s.Name,
+				Identity: rate.name,
+				Expected: 0,
+				Actual:   rate.value,
+				Severity: SeverityWarning,
+			})
+		}
+	}
+
+	return violations
+}
+
+// checkFlow verifies that every item a stage output reached the next
+// stage or is still sitting in the buffer between them.
+func checkFlow(from, to *Stage) []InvariantViolation {
+	out := from.GetCounts()
+	in := to.GetCounts()
+
+	if out.Output == in.Received+uint64(out.Buffered) {
+		return nil
+	}
+
+	return []InvariantViolation{{
+		Stage:    fmt.Sprintf("%s -> %s", from.Name, to.Name),
+		Identity: "output = next received + buffered",
+		Expected: float64(out.Output),
+		Actual:   float64(in.Received + uint64(out.Buffered)),
+		Severity: SeverityError,
+	}}
+}
+
+// logInvariants runs CheckInvariants at completion when enabled.
+func (s *Simulator) logInvariants() {
+	if !s.CheckInvariantsOnDone {
+		return
+	}
+
+	for _, v := range s.CheckInvariants() {
+		log.Printf("invariant violated: %s", v)
+	}
+}
@@ -0,0 +1,126 @@
+package simulator
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultCoreWorkers    = 1
+	defaultSpawnThreshold = 0.75
+	defaultSpawnInterval  = 10 * time.Millisecond
+)
+
+func (s *Stage) coreWorkers() int {
+	core := s.Config.CoreWorkers
+	if core <= 0 {
+		core = defaultCoreWorkers
+	}
+	return min(core, s.Config.RoutineNum)
+}
+
+func (s *Stage) spawnThreshold() float64 {
+	if s.Config.SpawnThreshold > 0 {
+		return s.Config.SpawnThreshold
+	}
+	return defaultSpawnThreshold
+}
+
+func (s *Stage) spawnInterval() time.Duration {
+	if s.Config.SpawnInterval > 0 {
+		return s.Config.SpawnInterval
+	}
+	return defaultSpawnInterval
+}
+
+// lazyEligible reports whether the stage would run in lazy mode,
+// generators, pooled stages and stepped runs ignore LazyWorkers.
+func (s *Stage) lazyEligible() bool {
+	return !s.isGenerator && s.pool == nil && !s.stepping
+}
+
+// initializeLazyWorkers starts the core workers and a spawner that adds
+// workers up to RoutineNum while the input stays congested.
+func (s *Stage) initializeLazyWorkers() {
+	core := s.coreWorkers()
+
+	// The spawner holds its own count so the stage can't finalize while
+	// it may still add workers.
+	s.retain(core + 1)
+	for range core {
+		s.startWorker()
+	}
+
+	s.spawn(s.spawner)
+}
+
+// startWorker launches a worker on the next metric slot, the caller must
+// have retained it.
+func (s *Stage) startWorker() {
+	slot := int(s.workersStarted.Add(1)) - 1
+	if s.profile == FastProfile {
+		s.spawn(func() { s.fastWorker(slot) })
+		return
+	}
+	s.spawn(func() { s.worker(slot) })
+}
+
+// spawner samples the input occupancy every SpawnInterval and adds a
+// worker when it's above the threshold on two consecutive checks. It
+// holds the stage open, so it stops on the run's end or once every
+// worker started, never on the stage's done.
+func (s *Stage) spawner() {
+	defer s.release()
+
+	ticker := s.clock.NewTicker(s.spawnInterval())
+	defer ticker.Stop()
+
+	var congested bool
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if int(s.workersStarted.Load()) >= s.Config.RoutineNum {
+			return
+		}
+
+		wasCongested := congested
+		congested = s.inputOccupancy() > s.spawnThreshold()
+		if !congested || !wasCongested {
+			continue
+		}
+
+		s.retain(1)
+		s.startWorker()
+		s.spawnEvents.Add(1)
+		congested = false
+	}
+}
+
+// inputOccupancy returns how full the input buffer is, validateConfig
+// rejects lazy stages with an unbuffered input.
+func (s *Stage) inputOccupancy() float64 {
+	capacity := cap(s.input)
+	if capacity == 0 {
+		return 0
+	}
+	return float64(len(s.input)) / float64(capacity)
+}
+
+// routinesLabel describes the stage's goroutines, in lazy mode it shows
+// the high-water count against the cap.
+func (s *Stage) routinesLabel() string {
+	if s.pool != nil {
+		return s.poolLabel()
+	}
+
+	if !s.Config.LazyWorkers || s.isGenerator {
+		return fmt.Sprint(s.Config.RoutineNum)
+	}
+
+	return fmt.Sprintf("%d of %d (lazy, %d spawned)",
+		s.workersStarted.Load(), s.Config.RoutineNum, s.spawnEvents.Load())
+}
@@ -0,0 +1,80 @@
+package simulator_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator"
+	"github.com/AlexsanderHamir/GoFlow/simulator/testkit"
+)
+
+// newLazySimulator builds a generator feeding a lazy stage of up to four
+// workers and a sink, filling in the fields every lazy test shares.
+func newLazySimulator(t *testing.T, generator, lazy simulator.StageConfig) (*simulator.Simulator, *simulator.Stage) {
+	t.Helper()
+
+	generator.RoutineNum = 1
+	generator.ItemGenerator = func() any { return 1 }
+
+	lazy.RoutineNum = 4
+	lazy.BufferSize = 10
+	lazy.LazyWorkers = true
+	lazy.SpawnInterval = time.Millisecond
+	lazy.WorkerFunc = func(item any) (any, error) { return item, nil }
+
+	stage := simulator.NewStage("Lazy", &lazy)
+
+	sim := simulator.NewSimulator()
+	for _, s := range []*simulator.Stage{
+		simulator.NewStage("Generator", &generator),
+		stage,
+		simulator.NewStage("Sink", &simulator.StageConfig{RoutineNum: 1}),
+	} {
+		if err := sim.AddStage(s); err != nil {
+			t.Fatalf("Failed to add stage %s: %v", s.Name, err)
+		}
+	}
+
+	return sim, stage
+}
+
+func TestLazyWorkersLightLoadKeepsCore(t *testing.T) {
+	sim, stage := newLazySimulator(t,
+		simulator.StageConfig{InputRate: 5 * time.Millisecond, BufferSize: 10},
+		simulator.StageConfig{})
+	testkit.RunFor(t, sim, 200*time.Millisecond)
+
+	if got := stage.WorkersStarted(); got != 1 {
+		t.Errorf("Expected only the core worker, got %d workers", got)
+	}
+	if got := stage.SpawnEvents(); got != 0 {
+		t.Errorf("Expected no spawns, got %d", got)
+	}
+	testkit.AssertAccounting(t, sim)
+}
+
+func TestLazyWorkersSaturatedLoadSpawnsToCap(t *testing.T) {
+	sim, stage := newLazySimulator(t,
+		simulator.StageConfig{BufferSize: 10},
+		simulator.StageConfig{WorkerDelay: 2 * time.Millisecond})
+	testkit.RunFor(t, sim, 300*time.Millisecond)
+
+	if got := stage.WorkersStarted(); got != 4 {
+		t.Errorf("Expected 4 workers, got %d", got)
+	}
+	if got := stage.SpawnEvents(); got != 3 {
+		t.Errorf("Expected 3 spawns, got %d", got)
+	}
+	testkit.AssertAccounting(t, sim)
+}
+
+func TestLazyWorkersRejectUnbufferedInput(t *testing.T) {
+	sim, _ := newLazySimulator(t, simulator.StageConfig{}, simulator.StageConfig{})
+	sim.Duration = 10 * time.Millisecond
+
+	err := sim.Start(simulator.Nothing)
+	if err == nil || !strings.Contains(err.Error(), "buffered input") {
+		t.Fatalf("Expected an unbuffered input error, got %v", err)
+	}
+}
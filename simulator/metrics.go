@@ -8,43 +8,231 @@ import (
 
 type stageMetrics struct {
 	mu             sync.RWMutex
+	receivedItems  uint64
 	processedItems uint64
 	droppedItems   uint64
 	outputItems    uint64
+	consumedItems  uint64
+	inFlightItems  uint64
+	filteredItems  uint64
 	startTime      time.Time
 	endTime        time.Time
+	clock          Clock
 	generatedItems uint64
+
+	// trackingEvictions counts select-case waits that weren't forwarded
+	// to the tracker because of TrackingMaxRecords.
+	trackingEvictions uint64
+
+	// shards holds one counter slot per worker when sharding is enabled,
+	// the shared counters above stay at zero in that case.
+	shards []metricShard
+}
+
+// metricShard is the counter slot of a single worker, padded to a cache
+// line so neighboring workers don't contend on the same line.
+type metricShard struct {
+	receivedItems  atomic.Uint64
+	processedItems atomic.Uint64
+	droppedItems   atomic.Uint64
+	outputItems    atomic.Uint64
+	generatedItems atomic.Uint64
+	consumedItems  atomic.Uint64
+	inFlightItems  atomic.Uint64
+	filteredItems  atomic.Uint64
 }
 
 func newStageMetrics() *stageMetrics {
 	return &stageMetrics{
 		startTime: time.Now(),
+		clock:     RealClock{},
+	}
+}
+
+// reset zeroes the counters in place and restarts the timing window.
+func (m *stageMetrics) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	atomic.StoreUint64(&m.receivedItems, 0)
+	atomic.StoreUint64(&m.processedItems, 0)
+	atomic.StoreUint64(&m.droppedItems, 0)
+	atomic.StoreUint64(&m.outputItems, 0)
+	atomic.StoreUint64(&m.generatedItems, 0)
+	atomic.StoreUint64(&m.consumedItems, 0)
+	atomic.StoreUint64(&m.inFlightItems, 0)
+	atomic.StoreUint64(&m.filteredItems, 0)
+	atomic.StoreUint64(&m.trackingEvictions, 0)
+	for i := range m.shards {
+		m.shards[i] = metricShard{}
+	}
+	m.startTime = m.clock.Now()
+	m.endTime = time.Time{}
+}
+
+// start restarts the timing window on the run's clock.
+func (m *stageMetrics) start(clock Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clock = clock
+	m.startTime = clock.Now()
+}
+
+// enableShards gives every worker its own counter slot, must be called
+// before any worker starts recording. Slots left by a previous run of
+// the same size are kept.
+func (m *stageMetrics) enableShards(workers int) {
+	if len(m.shards) != workers {
+		m.shards = make([]metricShard, workers)
+	}
+}
+
+func (m *stageMetrics) recordReceived(worker int) {
+	if m.shards != nil {
+		m.shards[worker].receivedItems.Add(1)
+		return
 	}
+	atomic.AddUint64(&m.receivedItems, 1)
 }
 
-func (m *stageMetrics) recordProcessed() {
+func (m *stageMetrics) recordProcessed(worker int) {
+	if m.shards != nil {
+		m.shards[worker].processedItems.Add(1)
+		return
+	}
 	atomic.AddUint64(&m.processedItems, 1)
 }
 
-func (m *stageMetrics) recordGenerated() {
+func (m *stageMetrics) recordGenerated(worker int) {
+	if m.shards != nil {
+		m.shards[worker].generatedItems.Add(1)
+		return
+	}
 	atomic.AddUint64(&m.generatedItems, 1)
 }
 
-func (m *stageMetrics) recordDropped() {
+func (m *stageMetrics) recordDropped(worker int) {
+	if m.shards != nil {
+		m.shards[worker].droppedItems.Add(1)
+		return
+	}
 	atomic.AddUint64(&m.droppedItems, 1)
 }
 
-func (m *stageMetrics) recordOutput() {
+func (m *stageMetrics) recordOutput(worker int) {
+	if m.shards != nil {
+		m.shards[worker].outputItems.Add(1)
+		return
+	}
 	atomic.AddUint64(&m.outputItems, 1)
 }
 
+func (m *stageMetrics) recordEvictions(n uint64) {
+	atomic.AddUint64(&m.trackingEvictions, n)
+}
+
+func (m *stageMetrics) loadEvictions() uint64 {
+	return atomic.LoadUint64(&m.trackingEvictions)
+}
+
+func (m *stageMetrics) loadReceived() uint64 {
+	total := atomic.LoadUint64(&m.receivedItems)
+	for i := range m.shards {
+		total += m.shards[i].receivedItems.Load()
+	}
+	return total
+}
+
+func (m *stageMetrics) recordConsumed(worker int) {
+	if m.shards != nil {
+		m.shards[worker].consumedItems.Add(1)
+		return
+	}
+	atomic.AddUint64(&m.consumedItems, 1)
+}
+
+func (m *stageMetrics) loadConsumed() uint64 {
+	total := atomic.LoadUint64(&m.consumedItems)
+	for i := range m.shards {
+		total += m.shards[i].consumedItems.Load()
+	}
+	return total
+}
+
+func (m *stageMetrics) recordInFlight(worker int) {
+	if m.shards != nil {
+		m.shards[worker].inFlightItems.Add(1)
+		return
+	}
+	atomic.AddUint64(&m.inFlightItems, 1)
+}
+
+func (m *stageMetrics) recordFiltered(worker int) {
+	if m.shards != nil {
+		m.shards[worker].filteredItems.Add(1)
+		return
+	}
+	atomic.AddUint64(&m.filteredItems, 1)
+}
+
+func (m *stageMetrics) loadFiltered() uint64 {
+	total := atomic.LoadUint64(&m.filteredItems)
+	for i := range m.shards {
+		total += m.shards[i].filteredItems.Load()
+	}
+	return total
+}
+
+func (m *stageMetrics) loadInFlight() uint64 {
+	total := atomic.LoadUint64(&m.inFlightItems)
+	for i := range m.shards {
+		total += m.shards[i].inFlightItems.Load()
+	}
+	return total
+}
+
+func (m *stageMetrics) loadProcessed() uint64 {
+	total := atomic.LoadUint64(&m.processedItems)
+	for i := range m.shards {
+		total += m.shards[i].processedItems.Load()
+	}
+	return total
+}
+
+func (m *stageMetrics) loadGenerated() uint64 {
+	total := atomic.LoadUint64(&m.generatedItems)
+	for i := range m.shards {
+		total += m.shards[i].generatedItems.Load()
+	}
+	return total
+}
+
+func (m *stageMetrics) loadDropped() uint64 {
+	total := atomic.LoadUint64(&m.droppedItems)
+	for i := range m.shards {
+		total += m.shards[i].droppedItems.Load()
+	}
+	return total
+}
+
+func (m *stageMetrics) loadOutput() uint64 {
+	total := atomic.LoadUint64(&m.outputItems)
+	for i := range m.shards {
+		total += m.shards[i].outputItems.Load()
+	}
+	return total
+}
+
 func (m *stageMetrics) stop() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.endTime = time.Now()
+	m.endTime = m.clock.Now()
 }
 
-// GetStats returns a map of current metrics
+// GetStats returns a map of current metrics, every stage reports the
+// same keys. The drop rate is over the generated items for a generator
+// and over the processed ones otherwise.
 func (m *stageMetrics) GetStats() map[string]any {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -52,63 +240,68 @@ func (m *stageMetrics) GetStats() map[string]any {
 	commonMap := m.getCommons()
 
 	drop := commonMap["dropped_items"].(uint64)
+	gen := m.loadGenerated()
+	processed := m.loadProcessed()
 
 	var dropRate float64
-
-	isGenerator := atomic.LoadUint64(&m.generatedItems) > 0
-	if isGenerator {
-		gen := atomic.LoadUint64(&m.generatedItems)
-
-		if drop > 0 {
-			dropRate = float64(drop) / float64(gen)
-		}
-
-		commonMap["generated_items"] = atomic.LoadUint64(&m.generatedItems)
-		commonMap["drop_rate"] = dropRate
-		return commonMap
+	switch {
+	case gen > 0:
+		dropRate = float64(drop) / float64(gen)
+	case processed > 0:
+		dropRate = float64(drop) / float64(processed)
 	}
 
-	processed := atomic.LoadUint64(&m.processedItems)
-	noProcessingHappaned := processed == 0
-	if noProcessingHappaned {
-		return m.getEmpty()
-	}
-
-	dropRate = float64(drop) / float64(processed)
-
+	commonMap["generated_items"] = gen
+	commonMap["generation_rate"] = m.rate(gen)
 	commonMap["processed_items"] = processed
 	commonMap["drop_rate"] = dropRate
 
 	return commonMap
 }
 
-func (m *stageMetrics) getEmpty() map[string]any {
+// getCommons returns the counters every stage reports. dropped_items,
+// and the drop_rate built on it, only count discarded items: the sink's
+// items are consumed_items and the ones held when the run stopped are
+// in_flight_items, both used to be reported as dropped. Items a worker
+// filtered out on purpose are filtered_items.
+func (m *stageMetrics) getCommons() map[string]any {
+	drop := m.loadDropped()
+	out := m.loadOutput()
+
 	return map[string]any{
-		"processed_items": 0,
-		"dropped_items":   0,
-		"drop_rate":       0.0,
-		"throughput":      0.0,
-		"output_items":    0,
+		"received_items":     m.loadReceived(),
+		"consumed_items":     m.loadConsumed(),
+		"in_flight_items":    m.loadInFlight(),
+		"filtered_items":     m.loadFiltered(),
+		"dropped_items":      drop,
+		"output_items":       out,
+		"throughput":         m.rate(out),
+		"tracking_evictions": m.loadEvictions(),
 	}
 }
 
-func (m *stageMetrics) getCommons() map[string]any {
-	duration := m.endTime.Sub(m.startTime)
-	if m.endTime.IsZero() {
-		duration = time.Since(m.startTime)
+// rate returns count per second over the stage's lifetime.
+func (m *stageMetrics) rate(count uint64) float64 {
+	duration := m.elapsed()
+	if duration.Seconds() <= 0 {
+		return 0
 	}
 
-	drop := atomic.LoadUint64(&m.droppedItems)
-	out := atomic.LoadUint64(&m.outputItems)
+	return float64(count) / duration.Seconds()
+}
 
-	var throughput float64
-	if duration.Seconds() > 0 {
-		throughput = float64(out) / duration.Seconds()
+// elapsed returns the stage's lifetime, up to now while it's running.
+// The caller must hold m.mu.
+func (m *stageMetrics) elapsed() time.Duration {
+	if m.endTime.IsZero() {
+		return m.clock.Now().Sub(m.startTime)
 	}
+	return m.endTime.Sub(m.startTime)
+}
 
-	return map[string]any{
-		"dropped_items": drop,
-		"output_items":  out,
-		"throughput":    throughput,
-	}
+// window returns the measured lifetime of the stage.
+func (m *stageMetrics) window() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.elapsed()
 }
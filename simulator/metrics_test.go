@@ -0,0 +1,96 @@
+package simulator
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestShardedMetricsSum(t *testing.T) {
+	m := newStageMetrics()
+	m.enableShards(4)
+
+	var wg sync.WaitGroup
+	for worker := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				m.recordReceived(worker)
+				m.recordProcessed(worker)
+				m.recordOutput(worker)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if m.loadReceived() != 4000 || m.loadProcessed() != 4000 || m.loadOutput() != 4000 {
+		t.Errorf("Expected 4000 of each counter, got received %d processed %d output %d",
+			m.loadReceived(), m.loadProcessed(), m.loadOutput())
+	}
+}
+
+func TestGetStatsKeysMatchAcrossStages(t *testing.T) {
+	generator := newStageMetrics()
+	generator.recordGenerated(0)
+	generator.recordOutput(0)
+
+	worker := newStageMetrics()
+	worker.recordReceived(0)
+	worker.recordProcessed(0)
+	worker.recordOutput(0)
+
+	sink := newStageMetrics()
+	sink.recordReceived(0)
+	sink.recordConsumed(0)
+
+	want := slices.Sorted(maps.Keys(worker.GetStats()))
+	for name, m := range map[string]*stageMetrics{"generator": generator, "sink": sink} {
+		if got := slices.Sorted(maps.Keys(m.GetStats())); !slices.Equal(got, want) {
+			t.Errorf("Expected the %s keys %v, got %v", name, want, got)
+		}
+	}
+
+	if consumed := sink.GetStats()["consumed_items"]; consumed != uint64(1) {
+		t.Errorf("Expected the sink to report 1 consumed item, got %v", consumed)
+	}
+}
+
+// BenchmarkRecordMetrics compares the shared counters against one shard
+// per worker with every worker recording at full speed.
+func BenchmarkRecordMetrics(b *testing.B) {
+	for _, workers := range []int{8, 32, 128} {
+		for _, sharded := range []bool{false, true} {
+			name := fmt.Sprintf("workers=%d/shared", workers)
+			if sharded {
+				name = fmt.Sprintf("workers=%d/sharded", workers)
+			}
+
+			b.Run(name, func(b *testing.B) {
+				m := newStageMetrics()
+				if sharded {
+					m.enableShards(workers)
+				}
+
+				perWorker := b.N/workers + 1
+				b.ResetTimer()
+
+				var wg sync.WaitGroup
+				for worker := range workers {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for range perWorker {
+							m.recordReceived(worker)
+							m.recordProcessed(worker)
+							m.recordOutput(worker)
+						}
+					}()
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
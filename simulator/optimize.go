@@ -0,0 +1,491 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// PipelineSpec is a pipeline blueprint that can be built again for every
+// run, the first stage is the generator and the last one the sink.
+type PipelineSpec struct {
+	Stages   []StageSpec
+	Duration time.Duration
+	Profile  Profile
+}
+
+// StageSpec is a stage of a PipelineSpec, every build gets its own copy
+// of the config.
+type StageSpec struct {
+	Name   string
+	Config StageConfig
+}
+
+// SLA is the target a configuration must meet.
+type SLA struct {
+	// MinThroughput is the items per second the sink must consume.
+	MinThroughput float64
+
+	// MaxDropRate is the highest fraction of the generated items the
+	// stages may drop in total.
+	MaxDropRate float64
+}
+
+// ParamRange is the values Min, Min+Step, ... up to Max, Step defaults
+// to 1.
+type ParamRange struct {
+	Min, Max, Step int
+}
+
+// StageSearch names a stage and the ranges searched for it.
+type StageSearch struct {
+	Stage      string
+	RoutineNum ParamRange
+	BufferSize ParamRange
+}
+
+// SearchSpace is what Optimize may change and how it weighs the cost.
+type SearchSpace struct {
+	Stages []StageSearch
+
+	// Seed orders the candidates, the same seed makes the same choices
+	// given the same measurements. Trials are timed runs whose counts
+	// vary with the scheduling, so a search only repeats exactly when
+	// its measurements do.
+	Seed uint64
+
+	// The cost of a configuration is the sum of its routines and buffer
+	// slots times these weights, defaults to 1 per routine and 0.01 per
+	// buffer slot.
+	RoutineWeight float64
+	BufferWeight  float64
+}
+
+// StageParams is the searched configuration of one stage.
+type StageParams struct {
+	RoutineNum int
+	BufferSize int
+}
+
+// TrialStats is what a trial's run measured.
+type TrialStats struct {
+	// Throughput is the items per second consumed by the sink over
+	// Window.
+	Throughput float64
+
+	// Window is how long the sink actually ran, a little over the
+	// spec's duration.
+	Window time.Duration
+
+	// DropRate is the fraction of the generated items that were dropped.
+	DropRate float64
+
+	Generated uint64
+	Consumed  uint64
+	Dropped   uint64
+
+	// Stages holds every stage's counters in pipeline order.
+	Stages []StageCounts
+}
+
+// Trial is one configuration the optimizer ran.
+type Trial struct {
+	// Params follows the order of SearchSpace.Stages.
+	Params []StageParams
+	Cost   float64
+	Stats  TrialStats
+	Met    bool
+}
+
+// OptimizeResult is the outcome of Optimize.
+type OptimizeResult struct {
+	// Best is the cheapest trial that met the SLA, or the one that came
+	// closest when none did.
+	Best *Trial
+
+	// History has every trial in the order it ran.
+	History []Trial
+
+	// Pruned counts the configurations skipped without running since a
+	// configuration with at least as many routines and buffer slots
+	// everywhere already failed.
+	Pruned int
+}
+
+const (
+	defaultRoutineWeight = 1
+	defaultBufferWeight  = 0.01
+)
+
+// Optimize searches the per-stage RoutineNum and BufferSize ranges for
+// the cheapest configuration meeting target, running at most budget
+// simulations of spec.Duration each. It climbs from the smallest
+// configuration, growing the setting that best closes the gap to the
+// SLA, and once met it shrinks settings while the SLA still holds.
+// The trials run in real time, so calls with the same seed can measure,
+// and then choose, differently, see SearchSpace.Seed.
+func Optimize(spec PipelineSpec, target SLA, space SearchSpace, budget int) (*OptimizeResult, error) {
+	o, err := newOptimizer(spec, target, space, budget)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.run()
+}
+
+type optimizer struct {
+	spec   PipelineSpec
+	target SLA
+	space  SearchSpace
+	budget int
+
+	// ranges has two entries per searched stage, its routines then its
+	// buffer, a configuration is a point with one value per range.
+	ranges  []ParamRange
+	indexes []int
+
+	// measure runs a trial, tests replace it with deterministic
+	// measurements.
+	measure func(params []StageParams) (TrialStats, error)
+
+	sim     *Simulator
+	rng     *rand.Rand
+	visited map[string]bool
+	failed  [][]int
+	best    int
+	result  OptimizeResult
+}
+
+func newOptimizer(spec PipelineSpec, target SLA, space SearchSpace, budget int) (*optimizer, error) {
+	if len(spec.Stages) < 3 {
+		return nil, errors.New("spec needs at least 3 stages")
+	}
+
+	if spec.Duration <= 0 {
+		return nil, errors.New("spec duration must be greater than 0")
+	}
+
+	if budget <= 0 {
+		return nil, errors.New("budget must be greater than 0")
+	}
+
+	if target.MinThroughput < 0 || target.MaxDropRate < 0 {
+		return nil, errors.New("SLA targets cannot be negative")
+	}
+
+	if len(space.Stages) == 0 {
+		return nil, errors.New("search space has no stages")
+	}
+
+	if space.RoutineWeight <= 0 {
+		space.RoutineWeight = defaultRoutineWeight
+	}
+
+	if space.BufferWeight <= 0 {
+		space.BufferWeight = defaultBufferWeight
+	}
+
+	o := &optimizer{
+		spec:    spec,
+		target:  target,
+		space:   space,
+		budget:  budget,
+		rng:     rand.New(rand.NewPCG(space.Seed, space.Seed)),
+		visited: make(map[string]bool),
+	}
+	o.measure = o.runTrial
+
+	for _, search := range space.Stages {
+		index := slices.IndexFunc(spec.Stages, func(stage StageSpec) bool {
+			return stage.Name == search.Stage
+		})
+		if index < 0 {
+			return nil, fmt.Errorf("search space stage not in spec: %s", search.Stage)
+		}
+
+		routines, err := search.RoutineNum.normalize(1)
+		if err != nil {
+			return nil, fmt.Errorf("stage %s routines: %w", search.Stage, err)
+		}
+
+		buffer, err := search.BufferSize.normalize(0)
+		if err != nil {
+			return nil, fmt.Errorf("stage %s buffer: %w", search.Stage, err)
+		}
+
+		o.ranges = append(o.ranges, routines, buffer)
+		o.indexes = append(o.indexes, index)
+	}
+
+	return o, nil
+}
+
+// normalize applies the default step and checks the range, floor is the
+// smallest valid value.
+func (r ParamRange) normalize(floor int) (ParamRange, error) {
+	if r.Step <= 0 {
+		r.Step = 1
+	}
+
+	if r.Min < floor {
+		return r, fmt.Errorf("minimum cannot be less than %d", floor)
+	}
+
+	if r.Max < r.Min {
+		return r, errors.New("maximum cannot be less than the minimum")
+	}
+
+	return r, nil
+}
+
+func (o *optimizer) run() (*OptimizeResult, error) {
+	point := make([]int, len(o.ranges))
+	for i, r := range o.ranges {
+		point[i] = r.Min
+	}
+
+	trial, err := o.evaluate(point)
+	if err != nil {
+		return nil, err
+	}
+
+	for trial != nil {
+		if trial.Met {
+			point, trial, err = o.shrink(point, trial)
+		} else {
+			point, trial, err = o.grow(point)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	o.result.Best = &o.result.History[o.best]
+	return &o.result, nil
+}
+
+// grow runs every larger neighbor and moves to the one closest to the
+// SLA, it returns a nil trial once there's nowhere left to go.
+func (o *optimizer) grow(point []int) ([]int, *Trial, error) {
+	var best []int
+	var bestTrial *Trial
+
+	for _, next := range o.neighbors(point, 1) {
+		trial, err := o.evaluate(next)
+		if err != nil || trial == nil {
+			return nil, nil, err
+		}
+
+		if bestTrial == nil || o.closer(trial, bestTrial) {
+			best, bestTrial = next, trial
+		}
+	}
+
+	return best, bestTrial, nil
+}
+
+// shrink moves to the first smaller neighbor that still meets the SLA,
+// it returns a nil trial at a local minimum.
+func (o *optimizer) shrink(point []int, current *Trial) ([]int, *Trial, error) {
+	for _, next := range o.neighbors(point, -1) {
+		trial, err := o.evaluate(next)
+		if err != nil || trial == nil {
+			return nil, nil, err
+		}
+
+		if trial.Met && trial.Cost < current.Cost {
+			return next, trial, nil
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// neighbors returns the points one step away in direction dir, in an
+// order drawn from the seed, skipping visited and pruned points.
+func (o *optimizer) neighbors(point []int, dir int) [][]int {
+	var out [][]int
+	for i, r := range o.ranges {
+		value := point[i] + dir*r.Step
+		if value < r.Min || value > r.Max {
+			continue
+		}
+
+		next := slices.Clone(point)
+		next[i] = value
+		if o.visited[fmt.Sprint(next)] {
+			continue
+		}
+
+		if o.dominated(next) {
+			o.visited[fmt.Sprint(next)] = true
+			o.result.Pruned++
+			continue
+		}
+
+		out = append(out, next)
+	}
+
+	o.rng.Shuffle(len(out), func(i, j int) {
+		out[i], out[j] = out[j], out[i]
+	})
+
+	return out
+}
+
+// dominated reports whether a failed point had at least as much of every
+// setting, more resources are assumed never to hurt.
+func (o *optimizer) dominated(point []int) bool {
+	for _, failed := range o.failed {
+		below := true
+		for i := range point {
+			if point[i] > failed[i] {
+				below = false
+				break
+			}
+		}
+		if below {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate runs the configuration at point and records the trial, it
+// returns a nil trial once the budget is spent.
+func (o *optimizer) evaluate(point []int) (*Trial, error) {
+	if len(o.result.History) >= o.budget {
+		return nil, nil
+	}
+	o.visited[fmt.Sprint(point)] = true
+
+	trial := &Trial{Params: make([]StageParams, len(o.indexes))}
+	for i := range o.indexes {
+		trial.Params[i] = StageParams{RoutineNum: point[2*i], BufferSize: point[2*i+1]}
+		trial.Cost += float64(point[2*i])*o.space.RoutineWeight + float64(point[2*i+1])*o.space.BufferWeight
+	}
+
+	stats, err := o.measure(trial.Params)
+	if err != nil {
+		return nil, err
+	}
+	trial.Stats = stats
+	trial.Met = stats.Throughput >= o.target.MinThroughput && stats.DropRate <= o.target.MaxDropRate
+
+	if !trial.Met {
+		o.failed = append(o.failed, slices.Clone(point))
+	}
+
+	if len(o.result.History) == 0 || o.better(trial, &o.result.History[o.best]) {
+		o.best = len(o.result.History)
+	}
+	o.result.History = append(o.result.History, *trial)
+
+	return trial, nil
+}
+
+// runTrial runs the spec once with params applied. The simulator is
+// built on the first trial and Reset for the next ones.
+func (o *optimizer) runTrial(params []StageParams) (TrialStats, error) {
+	if err := o.prepare(params); err != nil {
+		return TrialStats{}, err
+	}
+	stages := o.sim.GetStages()
+
+	if err := o.sim.Start(Nothing); err != nil {
+		return TrialStats{}, fmt.Errorf("trial run failed: %w", err)
+	}
+
+	stats := TrialStats{Stages: make([]StageCounts, len(stages))}
+	for i, stage := range stages {
+		counts := stage.GetCounts()
+		stats.Stages[i] = counts
+		stats.Dropped += counts.Dropped
+	}
+
+	stats.Generated = stats.Stages[0].Generated
+	stats.Consumed = stats.Stages[len(stages)-1].Consumed
+	stats.Window = stages[len(stages)-1].metrics.window()
+	if stats.Window > 0 {
+		stats.Throughput = float64(stats.Consumed) / stats.Window.Seconds()
+	}
+	if stats.Generated > 0 {
+		stats.DropRate = float64(stats.Dropped) / float64(stats.Generated)
+	}
+
+	return stats, nil
+}
+
+// prepare builds the simulator with params on the first trial, later
+// trials change the configs in place and Reset, which rebuilds the
+// output buffers with the new sizes.
+func (o *optimizer) prepare(params []StageParams) error {
+	if o.sim != nil {
+		stages := o.sim.GetStages()
+		for i, index := range o.indexes {
+			stages[index].Config.RoutineNum = params[i].RoutineNum
+			stages[index].Config.BufferSize = params[i].BufferSize
+		}
+		return o.sim.Reset()
+	}
+
+	configs := make([]StageConfig, len(o.spec.Stages))
+	for i, stage := range o.spec.Stages {
+		configs[i] = stage.Config
+	}
+
+	for i, index := range o.indexes {
+		configs[index].RoutineNum = params[i].RoutineNum
+		configs[index].BufferSize = params[i].BufferSize
+	}
+
+	o.sim = NewSimulator()
+	o.sim.Duration = o.spec.Duration
+	o.sim.Profile = o.spec.Profile
+
+	for i, stage := range o.spec.Stages {
+		if err := o.sim.AddStage(NewStage(stage.Name, &configs[i])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// shortfall is how far the trial is from the SLA, zero when it's met.
+func (o *optimizer) shortfall(t *Trial) float64 {
+	var gap float64
+	if o.target.MinThroughput > 0 && t.Stats.Throughput < o.target.MinThroughput {
+		gap += (o.target.MinThroughput - t.Stats.Throughput) / o.target.MinThroughput
+	}
+
+	if t.Stats.DropRate > o.target.MaxDropRate {
+		gap += t.Stats.DropRate - o.target.MaxDropRate
+	}
+
+	return gap
+}
+
+// closer orders failing trials by their shortfall, then by cost.
+func (o *optimizer) closer(a, b *Trial) bool {
+	gapA, gapB := o.shortfall(a), o.shortfall(b)
+	if gapA != gapB {
+		return gapA < gapB
+	}
+	return a.Cost < b.Cost
+}
+
+// better prefers trials that met the SLA, then the cheapest of those.
+func (o *optimizer) better(a, b *Trial) bool {
+	if a.Met != b.Met {
+		return a.Met
+	}
+
+	if a.Met {
+		return a.Cost < b.Cost
+	}
+
+	return o.closer(a, b)
+}
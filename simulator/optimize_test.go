@@ -0,0 +1,179 @@
+package simulator
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// undersizedSpec is a generator offering about 1000 items/s, a fast
+// stage, a Slow stage with a single 5ms routine that caps the pipeline
+// near 200 items/s, and a sink.
+func undersizedSpec() PipelineSpec {
+	pass := func(item any) (any, error) { return item, nil }
+
+	return PipelineSpec{
+		Duration: 200 * time.Millisecond,
+		Stages: []StageSpec{
+			{Name: "Generator", Config: StageConfig{
+				RoutineNum:    1,
+				BufferSize:    10,
+				InputRate:     time.Millisecond,
+				ItemGenerator: func() any { return 1 },
+			}},
+			{Name: "Fast", Config: StageConfig{RoutineNum: 1, BufferSize: 10, WorkerFunc: pass}},
+			{Name: "Slow", Config: StageConfig{
+				RoutineNum:  1,
+				BufferSize:  10,
+				WorkerDelay: 5 * time.Millisecond,
+				WorkerFunc:  pass,
+			}},
+			{Name: "Sink", Config: StageConfig{RoutineNum: 1}},
+		},
+	}
+}
+
+func TestOptimizeGrowsUndersizedStage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs up to 20 simulations")
+	}
+
+	target := SLA{MinThroughput: 500, MaxDropRate: 0}
+	space := SearchSpace{
+		Stages: []StageSearch{{
+			Stage:      "Slow",
+			RoutineNum: ParamRange{Min: 1, Max: 8},
+			BufferSize: ParamRange{Min: 10, Max: 10},
+		}},
+		Seed: 1,
+	}
+
+	result, err := Optimize(undersizedSpec(), target, space, 20)
+	if err != nil {
+		t.Fatalf("Failed to optimize: %v", err)
+	}
+
+	if len(result.History) > 20 {
+		t.Errorf("Expected at most 20 trials, got %d", len(result.History))
+	}
+
+	first := result.History[0]
+	if first.Met || first.Params[0].RoutineNum != 1 {
+		t.Errorf("Expected the first trial to be the failing single routine, got %+v", first.Params[0])
+	}
+
+	best := result.Best
+	if !best.Met {
+		t.Fatalf("Expected the SLA to be met, closest was %+v at %.0f items/s", best.Params[0], best.Stats.Throughput)
+	}
+
+	if best.Stats.Throughput < target.MinThroughput {
+		t.Errorf("Expected at least %.0f items/s, got %.0f", target.MinThroughput, best.Stats.Throughput)
+	}
+
+	// 5ms per item makes each routine worth about 200 items/s.
+	if routines := best.Params[0].RoutineNum; routines < 3 {
+		t.Errorf("Expected at least 3 routines on the slow stage, got %d", routines)
+	}
+
+	for _, trial := range result.History {
+		if trial.Met && trial.Cost < best.Cost {
+			t.Errorf("Expected the cheapest met trial, %+v costs %.2f less than %.2f", trial.Params[0], trial.Cost, best.Cost)
+		}
+	}
+}
+
+func TestOptimizeRejectsUnknownStage(t *testing.T) {
+	space := SearchSpace{Stages: []StageSearch{{
+		Stage:      "Missing",
+		RoutineNum: ParamRange{Min: 1, Max: 2},
+	}}}
+
+	_, err := Optimize(undersizedSpec(), SLA{MinThroughput: 1}, space, 1)
+	if err == nil {
+		t.Fatal("Expected an error for a stage missing from the spec")
+	}
+}
+
+// capacityMeasure is a deterministic measurement: every routine of the
+// searched stages is worth perRoutine items/s, buffers add nothing.
+func capacityMeasure(perRoutine float64) func([]StageParams) (TrialStats, error) {
+	return func(params []StageParams) (TrialStats, error) {
+		var stats TrialStats
+		for _, p := range params {
+			stats.Throughput += float64(p.RoutineNum) * perRoutine
+		}
+		return stats, nil
+	}
+}
+
+// searchWith runs the search with measure in place of the timed runs.
+func searchWith(t *testing.T, space SearchSpace, measure func([]StageParams) (TrialStats, error)) *OptimizeResult {
+	t.Helper()
+
+	o, err := newOptimizer(undersizedSpec(), SLA{MinThroughput: 1000}, space, 30)
+	if err != nil {
+		t.Fatalf("Failed to create the optimizer: %v", err)
+	}
+	o.measure = measure
+
+	result, err := o.run()
+	if err != nil {
+		t.Fatalf("Failed to optimize: %v", err)
+	}
+	return result
+}
+
+func TestOptimizeSeedRepeatsTheSearch(t *testing.T) {
+	space := func(seed uint64) SearchSpace {
+		return SearchSpace{
+			Stages: []StageSearch{
+				{Stage: "Fast", RoutineNum: ParamRange{Min: 1, Max: 6}},
+				{Stage: "Slow", RoutineNum: ParamRange{Min: 1, Max: 6}},
+			},
+			Seed: seed,
+		}
+	}
+	path := func(result *OptimizeResult) string {
+		var params []any
+		for _, trial := range result.History {
+			params = append(params, trial.Params)
+		}
+		return fmt.Sprint(params)
+	}
+
+	first := searchWith(t, space(1), capacityMeasure(100))
+	second := searchWith(t, space(1), capacityMeasure(100))
+	if path(first) != path(second) {
+		t.Errorf("Expected the same trials for the same seed and measurements, got\n%s\n%s", path(first), path(second))
+	}
+	if !reflect.DeepEqual(first.Best.Params, second.Best.Params) {
+		t.Errorf("Expected the same best trial, got %+v and %+v", first.Best.Params, second.Best.Params)
+	}
+
+	other := searchWith(t, space(2), capacityMeasure(100))
+	if path(first) == path(other) {
+		t.Error("Expected a different seed to change the order of the trials")
+	}
+}
+
+func TestOptimizeThroughputOverMeasuredWindow(t *testing.T) {
+	spec := undersizedSpec()
+	spec.Duration = 50 * time.Millisecond
+	space := SearchSpace{Stages: []StageSearch{{Stage: "Slow", RoutineNum: ParamRange{Min: 1, Max: 1}}}}
+
+	result, err := Optimize(spec, SLA{MinThroughput: 1}, space, 1)
+	if err != nil {
+		t.Fatalf("Failed to optimize: %v", err)
+	}
+
+	stats := result.Best.Stats
+	if stats.Window < spec.Duration {
+		t.Errorf("Expected a window of at least %s, got %s", spec.Duration, stats.Window)
+	}
+
+	if want := float64(stats.Consumed) / stats.Window.Seconds(); stats.Throughput != want {
+		t.Errorf("Expected %.2f items/s over the measured window, got %.2f", want, stats.Throughput)
+	}
+}
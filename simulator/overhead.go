@@ -0,0 +1,127 @@
+package simulator
+
+import (
+	"fmt"
+	"time"
+)
+
+// OverheadConfig shapes the no-op pipeline used to measure the
+// simulator's own cost, zero values fall back to the defaults.
+type OverheadConfig struct {
+	// Goroutines per stage, defaults to 1.
+	Width int
+
+	// Worker stages between the generator and the sink, defaults to 1.
+	Depth int
+
+	// How long the calibration pipeline runs, defaults to one second.
+	Duration time.Duration
+}
+
+// Calibration is the framework overhead measured on a no-op pipeline.
+type Calibration struct {
+	Width    int
+	Depth    int
+	Duration time.Duration
+	Profile  Profile
+
+	// Elapsed is the sink's measured window, the run can overshoot
+	// Duration or stop early.
+	Elapsed time.Duration
+
+	// Items that made it through every stage to the sink.
+	Items uint64
+
+	// Goroutine time each stage spends per item at saturation, the cost
+	// the simulator adds to every item regardless of the configured
+	// delays. It's the Elapsed time times the Width goroutines working
+	// in parallel in every stage, over the items.
+	NsPerItem float64
+
+	// Maximum sustainable end-to-end throughput in items per second.
+	MaxThroughput float64
+}
+
+// String formats the calibration for the final report.
+func (c *Calibration) String() string {
+	return fmt.Sprintf("framework overhead: %.0f ns/item, max %.2f items/s (width %d, depth %d, %s, %s profile)",
+		c.NsPerItem, c.MaxThroughput, c.Width, c.Depth, c.Duration, c.Profile)
+}
+
+func (c *OverheadConfig) withDefaults() OverheadConfig {
+	cfg := *c
+	if cfg.Width <= 0 {
+		cfg.Width = 1
+	}
+
+	if cfg.Depth <= 0 {
+		cfg.Depth = 1
+	}
+
+	if cfg.Duration <= 0 {
+		cfg.Duration = time.Second
+	}
+
+	return cfg
+}
+
+// MeasureOverhead runs a pipeline with zero delays and a trivial worker
+// to measure how much of the measured latency and throughput is the
+// simulator itself. The result is kept and annotates the console report
+// of later runs of this simulator.
+func (s *Simulator) MeasureOverhead(cfg OverheadConfig) (*Calibration, error) {
+	cfg = cfg.withDefaults()
+
+	calibration := NewSimulator()
+	calibration.Duration = cfg.Duration
+	calibration.Profile = s.Profile
+
+	stageConfig := &StageConfig{
+		ItemGenerator: func() any { return 0 },
+		WorkerFunc:    func(item any) (any, error) { return item, nil },
+		RoutineNum:    cfg.Width,
+		BufferSize:    cfg.Width,
+	}
+
+	names := make([]string, 0, cfg.Depth+2)
+	names = append(names, "Generator")
+	for i := range cfg.Depth {
+		names = append(names, fmt.Sprintf("Worker-%d", i+1))
+	}
+	names = append(names, "Sink")
+
+	for _, name := range names {
+		if err := calibration.AddStage(NewStage(name, stageConfig)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := calibration.Start(Nothing); err != nil {
+		return nil, fmt.Errorf("calibration run failed: %w", err)
+	}
+
+	stages := calibration.GetStages()
+	sink := stages[len(stages)-1]
+	items := sink.metrics.loadConsumed()
+	elapsed := sink.metrics.window()
+
+	result := &Calibration{
+		Width:    cfg.Width,
+		Depth:    cfg.Depth,
+		Duration: cfg.Duration,
+		Elapsed:  elapsed,
+		Profile:  s.Profile,
+		Items:    items,
+	}
+
+	if items > 0 && elapsed > 0 {
+		result.NsPerItem = float64(elapsed.Nanoseconds()) * float64(cfg.Width) / float64(items)
+		result.MaxThroughput = float64(items) / elapsed.Seconds()
+	}
+
+	s.mu.Lock()
+	s.calibration = result
+	s.mu.Unlock()
+
+	return result, nil
+}
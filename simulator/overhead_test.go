@@ -0,0 +1,119 @@
+package simulator
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMeasureOverheadUsesTheMeasuredWindow(t *testing.T) {
+	sim := NewSimulator()
+
+	started := time.Now()
+	calibration, err := sim.MeasureOverhead(OverheadConfig{Width: 4, Depth: 2, Duration: 100 * time.Millisecond})
+	wall := time.Since(started)
+	if err != nil {
+		t.Fatalf("Failed to measure the overhead: %v", err)
+	}
+
+	items := float64(calibration.Items)
+	if items == 0 {
+		t.Fatal("Expected the calibration run to consume items")
+	}
+
+	// The sink's window covers the whole run and fits in the call.
+	if calibration.Elapsed < calibration.Duration || calibration.Elapsed > wall {
+		t.Errorf("Expected the measured window between %s and %s, got %s", calibration.Duration, wall, calibration.Elapsed)
+	}
+
+	// Width 4 goroutines per stage over the window, per item.
+	low := float64(calibration.Duration.Nanoseconds()) * 4 / items
+	high := float64(wall.Nanoseconds()) * 4 / items
+	if calibration.NsPerItem < low || calibration.NsPerItem > high {
+		t.Errorf("Expected between %.2f and %.2f ns/item, got %.2f", low, high, calibration.NsPerItem)
+	}
+
+	low, high = items/wall.Seconds(), items/calibration.Duration.Seconds()
+	if calibration.MaxThroughput < low || calibration.MaxThroughput > high {
+		t.Errorf("Expected between %.2f and %.2f items/s, got %.2f", low, high, calibration.MaxThroughput)
+	}
+
+	if sim.calibration != calibration {
+		t.Error("Expected the calibration to be kept for the report")
+	}
+}
+
+// benchStage returns a stage ready to run its hot paths outside a
+// simulation, its output is drained until the benchmark ends.
+func benchStage(b *testing.B, config *StageConfig) *Stage {
+	stage := NewStage("Bench", config)
+	stage.ctx = context.Background()
+
+	done := make(chan struct{})
+	output := stage.output
+	go func() {
+		defer close(done)
+		for range output {
+		}
+	}()
+
+	b.Cleanup(func() {
+		close(output)
+		<-done
+	})
+
+	return stage
+}
+
+// BenchmarkGeneratorPath is the unpaced generation of one item.
+func BenchmarkGeneratorPath(b *testing.B) {
+	stage := benchStage(b, &StageConfig{
+		RoutineNum:    1,
+		BufferSize:    1024,
+		ItemGenerator: func() any { return 1 },
+	})
+	stage.isGenerator = true
+
+	b.ReportAllocs()
+	for range b.N {
+		stage.handleGeneration(0)
+	}
+}
+
+// BenchmarkWorkerPath is the processing and forwarding of one item.
+func BenchmarkWorkerPath(b *testing.B) {
+	stage := benchStage(b, &StageConfig{
+		RoutineNum: 1,
+		BufferSize: 1024,
+		WorkerFunc: func(item any) (any, error) { return item, nil },
+	})
+
+	b.ReportAllocs()
+	for range b.N {
+		stage.metrics.recordReceived(0)
+		stage.handleItem(1, 0)
+	}
+}
+
+// BenchmarkSendPath is the output send alone, with room in the buffer
+// and with the buffer full under DropOnBackpressure.
+func BenchmarkSendPath(b *testing.B) {
+	b.Run("send", func(b *testing.B) {
+		stage := benchStage(b, &StageConfig{RoutineNum: 1, BufferSize: 1024})
+
+		b.ReportAllocs()
+		for range b.N {
+			stage.sendOutput(1, 0)
+		}
+	})
+
+	b.Run("drop", func(b *testing.B) {
+		stage := NewStage("Bench", &StageConfig{RoutineNum: 1, DropOnBackpressure: true})
+		stage.ctx = context.Background()
+
+		b.ReportAllocs()
+		for range b.N {
+			stage.sendOutput(1, 0)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package simulator_test
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator"
+	"github.com/AlexsanderHamir/GoFlow/simulator/testkit"
+)
+
+// dirFiles returns the names of the files in dir, sorted.
+func dirFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", dir, err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func TestWritePipelineDotWritesEveryFile(t *testing.T) {
+	const workers = 12
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	sim := testkit.NewPipeline(t, testkit.PipelineOptions{Workers: workers, BufferSize: 4})
+	sim.ArtifactWorkers = 4
+	testkit.RunFor(t, sim, 20*time.Millisecond)
+
+	if err := sim.WritePipelineDot("pipeline.dot"); err != nil {
+		t.Fatalf("Failed to write the artifacts: %v", err)
+	}
+
+	want := []string{"pipeline.dot"}
+	for i := range workers {
+		want = append(want, fmt.Sprintf("Stage-%d.dot", i+1))
+	}
+	slices.Sort(want)
+
+	got := dirFiles(t, dir)
+	if !slices.Equal(got, want) {
+		t.Fatalf("Expected files %v, got %v", want, got)
+	}
+
+	for _, name := range got {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+
+		out := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(out, "digraph ") || !strings.HasSuffix(out, "}") {
+			t.Errorf("Expected %s to hold a complete digraph, got:\n%s", name, out)
+		}
+	}
+}
+
+func TestWritePipelineDotRefusesCollisions(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	sim := simulator.NewSimulator()
+	pass := func(item any) (any, error) { return item, nil }
+	for _, stage := range []*simulator.Stage{
+		simulator.NewStage("Generator", &simulator.StageConfig{RoutineNum: 1, ItemGenerator: func() any { return 1 }}),
+		simulator.NewStage("A B", &simulator.StageConfig{RoutineNum: 1, WorkerFunc: pass}),
+		simulator.NewStage("A_B", &simulator.StageConfig{RoutineNum: 1, WorkerFunc: pass}),
+		simulator.NewStage("Sink", &simulator.StageConfig{RoutineNum: 1}),
+	} {
+		if err := sim.AddStage(stage); err != nil {
+			t.Fatalf("Failed to add stage %s: %v", stage.Name, err)
+		}
+	}
+	testkit.RunFor(t, sim, 10*time.Millisecond)
+
+	err := sim.WritePipelineDot("pipeline.dot")
+	if err == nil || !strings.Contains(err.Error(), `stage "A_B"`) {
+		t.Fatalf("Expected a collision error naming stage A_B, got %v", err)
+	}
+
+	if files := dirFiles(t, dir); len(files) != 0 {
+		t.Errorf("Expected nothing written, got %v", files)
+	}
+}
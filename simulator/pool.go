@@ -0,0 +1,187 @@
+package simulator
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/AlexsanderHamir/IdleSpy/tracker"
+)
+
+// workerPool is a bounded set of goroutines shared by every worker
+// stage, used instead of per-stage goroutines for very large topologies.
+type workerPool struct {
+	tasks chan poolTask
+	wg    sync.WaitGroup
+
+	// running counts the pool goroutines that haven't returned, it's
+	// decremented as their last action.
+	running atomic.Int64
+}
+
+// poolTask carries the stage with the item so the accounting, retries
+// and backpressure stay the stage's own.
+type poolTask struct {
+	stage *Stage
+	item  any
+	slot  int
+}
+
+func newWorkerPool(size int) *workerPool {
+	p := &workerPool{tasks: make(chan poolTask)}
+
+	p.wg.Add(size)
+	p.running.Add(int64(size))
+	for range size {
+		go p.run()
+	}
+
+	return p
+}
+
+func (p *workerPool) run() {
+	// running drops last, after close stopped waiting for the goroutine.
+	defer p.running.Add(-1)
+	defer p.wg.Done()
+
+	for task := range p.tasks {
+		task.run()
+	}
+}
+
+// submit hands the task to an idle pool goroutine, it returns false when
+// they're all busy.
+func (p *workerPool) submit(task poolTask) bool {
+	select {
+	case p.tasks <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the pool goroutines once every stage has finished.
+func (p *workerPool) close() {
+	close(p.tasks)
+	p.wg.Wait()
+}
+
+func (t poolTask) run() {
+	defer func() {
+		// The slot sits idle from here until it's handed the next item,
+		// the equivalent of a worker waiting on its input.
+		if sampler := t.stage.sampler(t.slot); sampler != nil {
+			sampler.begin()
+		}
+		t.stage.slots <- t.slot
+		t.stage.inflight.Done()
+	}()
+
+	t.stage.handleItem(t.item, t.slot)
+}
+
+// initializePooledStage starts the single feeder that moves the stage's
+// input into the shared pool. Each slot stands in for one of the
+// RoutineNum workers the stage would own, it's tracked as a goroutine
+// of its own so the blocked time matches the per-stage mode.
+func (s *Stage) initializePooledStage() {
+	s.slots = make(chan int, s.Config.RoutineNum)
+	if s.outputs != nil {
+		s.samplers = make([]*waitSampler, s.Config.RoutineNum)
+	}
+
+	for slot := range s.Config.RoutineNum {
+		if s.samplers != nil {
+			id := s.trackSlot()
+			s.samplers[slot] = newWaitSampler(s, id)
+			s.outputs[slot] = newOutputSampler(s, id)
+			s.samplers[slot].begin()
+		}
+		s.slots <- slot
+	}
+
+	s.retain(1)
+	s.workersStarted.Store(1)
+	s.spawn(s.feeder)
+}
+
+// trackSlot registers a slot with the tracker as a goroutine of its own.
+// The tracker keys goroutines by the caller's runtime id and a slot runs
+// on whichever pool goroutine is free, so the slot is registered by a
+// goroutine started for it, whose id no other goroutine gets.
+func (s *Stage) trackSlot() tracker.GoroutineId {
+	ids := make(chan tracker.GoroutineId, 1)
+	s.spawn(func() { ids <- s.trackStart() })
+	return <-ids
+}
+
+// feeder reads the stage's input and submits each item to the shared
+// pool. A slot must be free first, which caps the stage's concurrency at
+// RoutineNum and doubles as the metric shard. When the pool is saturated
+// the feeder runs the item itself, so the sink always drains and the
+// shared pool can't deadlock on backpressure. The feeder isn't tracked,
+// the slots carry the stage's blocked time.
+func (s *Stage) feeder() {
+	// The in-flight items must finish before the output is closed.
+	defer func() {
+		s.inflight.Wait()
+		s.endSlots()
+		s.release()
+	}()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case item, ok := <-s.input:
+			if !ok {
+				return
+			}
+			s.metrics.recordReceived(0)
+
+			if !s.dispatch(item) {
+				return
+			}
+		}
+	}
+}
+
+// dispatch waits for a free slot and runs the item on the pool, it
+// returns false if the simulation ended while waiting.
+func (s *Stage) dispatch(item any) bool {
+	var slot int
+	select {
+	case <-s.ctx.Done():
+		s.metrics.recordInFlight(0)
+		return false
+	case slot = <-s.slots:
+	}
+
+	if sampler := s.sampler(slot); sampler != nil {
+		sampler.end()
+	}
+
+	s.inflight.Add(1)
+	task := poolTask{stage: s, item: item, slot: slot}
+	if !s.pool.submit(task) {
+		task.run()
+	}
+
+	return true
+}
+
+// endSlots closes the idle wait of every slot and queues them for the
+// tracker finalization, every item must have finished.
+func (s *Stage) endSlots() {
+	for slot, sampler := range s.samplers {
+		sampler.end()
+		sampler.flush()
+		s.outputs[slot].flush()
+		s.ended[slot] = endedGoroutine{id: sampler.id, tracked: true}
+	}
+}
+
+// poolLabel describes the goroutines of a pooled stage.
+func (s *Stage) poolLabel() string {
+	return fmt.Sprintf("%d (shared pool)", s.Config.RoutineNum)
+}
@@ -0,0 +1,174 @@
+package simulator_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator"
+	"github.com/AlexsanderHamir/GoFlow/simulator/testkit"
+)
+
+// poolRun is what a run reports for a single stage.
+type poolRun struct {
+	processed  uint64
+	goroutines int
+	blocked    time.Duration
+
+	// output is the part of blocked spent on backpressure.
+	output time.Duration
+}
+
+// runStageModes runs the pipeline built by build once with per-stage
+// goroutines and once on a shared pool, returning the observed stage.
+func runStageModes(t *testing.T, build func() *simulator.Simulator, observed string) (perStage, pooled poolRun) {
+	t.Helper()
+
+	run := func(poolSize int) poolRun {
+		sim := build()
+		sim.SharedPoolSize = poolSize
+		testkit.RunFor(t, sim, 300*time.Millisecond)
+		testkit.AssertAccounting(t, sim)
+
+		for _, stage := range sim.GetStages() {
+			if stage.Name != observed {
+				continue
+			}
+
+			r := poolRun{processed: stage.GetCounts().Processed}
+			for _, stats := range stage.Tracker().GetAllStats() {
+				r.goroutines++
+				r.blocked += stats.GetTotalSelectBlockedTime()
+				if output := stats.GetSelectCaseStats(simulator.OutputCaseLabel(observed)); output != nil {
+					r.output += output.BlockedCaseTime
+				}
+			}
+			return r
+		}
+
+		t.Fatalf("Stage %s not found", observed)
+		return poolRun{}
+	}
+
+	return run(0), run(8)
+}
+
+// assertClose fails unless got is within a factor of 2 of want.
+func assertClose(t *testing.T, what string, want, got float64) {
+	t.Helper()
+
+	if got < want/2 || got > want*2 {
+		t.Errorf("Expected pooled %s close to %.0f, got %.0f", what, want, got)
+	}
+}
+
+func TestPooledStageMatchesPerStageGoroutines(t *testing.T) {
+	build := func() *simulator.Simulator {
+		sim := simulator.NewSimulator()
+		stages := []*simulator.Stage{
+			simulator.NewStage("Generator", &simulator.StageConfig{
+				RoutineNum:    1,
+				BufferSize:    16,
+				InputRate:     time.Millisecond,
+				ItemGenerator: func() any { return 1 },
+			}),
+			simulator.NewStage("Work", &simulator.StageConfig{
+				RoutineNum:  4,
+				BufferSize:  16,
+				WorkerDelay: 2 * time.Millisecond,
+				WorkerFunc:  func(item any) (any, error) { return item, nil },
+			}),
+			simulator.NewStage("Sink", &simulator.StageConfig{RoutineNum: 1}),
+		}
+		for _, stage := range stages {
+			if err := sim.AddStage(stage); err != nil {
+				t.Fatalf("Failed to add stage %s: %v", stage.Name, err)
+			}
+		}
+		return sim
+	}
+
+	perStage, pooled := runStageModes(t, build, "Work")
+
+	if pooled.goroutines != perStage.goroutines {
+		t.Errorf("Expected %d tracked goroutines, got %d", perStage.goroutines, pooled.goroutines)
+	}
+	assertClose(t, "processed items", float64(perStage.processed), float64(pooled.processed))
+	assertClose(t, "blocked time", float64(perStage.blocked), float64(pooled.blocked))
+}
+
+func TestPooledStageTracksBackpressure(t *testing.T) {
+	build := func() *simulator.Simulator {
+		sim := simulator.NewSimulator()
+		stages := []*simulator.Stage{
+			simulator.NewStage("Generator", &simulator.StageConfig{
+				RoutineNum:    1,
+				BufferSize:    1,
+				ItemGenerator: func() any { return 1 },
+			}),
+			simulator.NewStage("Work", &simulator.StageConfig{
+				RoutineNum: 2,
+				BufferSize: 1,
+				WorkerFunc: func(item any) (any, error) { return item, nil },
+			}),
+			simulator.NewStage("Slow", &simulator.StageConfig{
+				RoutineNum:  1,
+				BufferSize:  1,
+				WorkerDelay: 5 * time.Millisecond,
+				WorkerFunc:  func(item any) (any, error) { return item, nil },
+			}),
+			simulator.NewStage("Sink", &simulator.StageConfig{RoutineNum: 1}),
+		}
+		for _, stage := range stages {
+			if err := sim.AddStage(stage); err != nil {
+				t.Fatalf("Failed to add stage %s: %v", stage.Name, err)
+			}
+		}
+		return sim
+	}
+
+	perStage, pooled := runStageModes(t, build, "Work")
+
+	// Work's two goroutines spend nearly the whole run blocked on Slow,
+	// the input is always ready. Half the run each is a loose bound, all
+	// of it under the output case.
+	minBlocked := 300 * time.Millisecond
+	for mode, r := range map[string]poolRun{"per-stage": perStage, "pooled": pooled} {
+		if r.output < minBlocked {
+			t.Errorf("Expected %s backpressure time of at least %s, got %s", mode, minBlocked, r.output)
+		}
+		if input := r.blocked - r.output; input > r.output/2 {
+			t.Errorf("Expected %s input waits well below the backpressure, got %s against %s", mode, input, r.output)
+		}
+	}
+	assertClose(t, "blocked time", float64(perStage.blocked), float64(pooled.blocked))
+}
+
+func TestPoolClosedWhenStartFails(t *testing.T) {
+	sim := simulator.NewSimulator()
+	sim.SharedPoolSize = 16
+	for _, stage := range []*simulator.Stage{
+		simulator.NewStage("Generator", &simulator.StageConfig{RoutineNum: 1, ItemGenerator: func() any { return 1 }}),
+		simulator.NewStage("Work", &simulator.StageConfig{RoutineNum: 1}),
+		simulator.NewStage("Sink", &simulator.StageConfig{RoutineNum: 1}),
+	} {
+		if err := sim.AddStage(stage); err != nil {
+			t.Fatalf("Failed to add stage %s: %v", stage.Name, err)
+		}
+	}
+
+	before := runtime.NumGoroutine()
+	if err := sim.Start(simulator.Nothing); err == nil {
+		t.Fatal("Expected a worker stage without WorkerFunc to fail the start")
+	}
+
+	// The pool goroutines return right after close released them.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected the failed start to leave no goroutines, %d before and %d after", before, after)
+	}
+}
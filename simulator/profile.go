@@ -0,0 +1,42 @@
+package simulator
+
+// Profile selects how much instrumentation the stages carry.
+type Profile int
+
+const (
+	// DefaultProfile tracks the blocked time of every worker goroutine.
+	DefaultProfile Profile = iota
+	// FastProfile builds the workers from stripped-down loops with no
+	// goroutine tracking and no per-item timestamps, keeping only the
+	// item counters. Processed, output and dropped keep the same meaning.
+	FastProfile
+)
+
+// String returns the profile's name as the report header shows it.
+func (p Profile) String() string {
+	switch p {
+	case FastProfile:
+		return "fast"
+	default:
+		return "default"
+	}
+}
+
+// fastWorker is the worker used by the fast profile, it has the same
+// accounting as worker without any tracking.
+func (s *Stage) fastWorker(slot int) {
+	defer s.release()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case item, ok := <-s.input:
+			if !ok {
+				return
+			}
+			s.metrics.recordReceived(slot)
+			s.handleItem(item, slot)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package simulator
+
+import (
+	"context"
+	"testing"
+)
+
+// BenchmarkWorkerLoop is a worker goroutine's cost per item under each
+// profile, from receiving the item to forwarding it.
+func BenchmarkWorkerLoop(b *testing.B) {
+	for _, profile := range []Profile{DefaultProfile, FastProfile} {
+		b.Run(profile.String(), func(b *testing.B) {
+			stage := NewStage("Bench", &StageConfig{
+				RoutineNum: 1,
+				BufferSize: 1024,
+				WorkerFunc: func(item any) (any, error) { return item, nil },
+			})
+			stage.ctx = context.Background()
+			stage.profile = profile
+			stage.ended = make([]endedGoroutine, 1)
+			stage.metrics.start(stage.clock)
+			if profile != FastProfile {
+				stage.outputs = make([]*waitSampler, 1)
+			}
+
+			input := make(chan any, 1024)
+			stage.input = input
+			go func() {
+				for range b.N {
+					input <- 1
+				}
+				close(input)
+			}()
+
+			// The worker closes the output when the input runs out.
+			drained := make(chan struct{})
+			go func() {
+				defer close(drained)
+				for range stage.output {
+				}
+			}()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			stage.retain(1)
+			if profile == FastProfile {
+				stage.fastWorker(0)
+			} else {
+				stage.worker(0)
+			}
+			<-drained
+		})
+	}
+}
@@ -1,11 +1,11 @@
 package simulator
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
-	"os"
-	"strings"
+	"io"
 	"sync"
 	"time"
 
@@ -26,16 +26,75 @@ const (
 	Nothing
 )
 
+// StatsSortKey selects the column used to order the console stats table.
+type StatsSortKey string
+
+const (
+	// SortByPipeline keeps the stages in pipeline order (default).
+	SortByPipeline StatsSortKey = ""
+	// SortByProcessed orders the stages by processed items.
+	SortByProcessed StatsSortKey = "processed"
+	// SortByOutput orders the stages by output items.
+	SortByOutput StatsSortKey = "output"
+	// SortByThroughput orders the stages by throughput.
+	SortByThroughput StatsSortKey = "throughput"
+	// SortByDropped orders the stages by dropped items.
+	SortByDropped StatsSortKey = "dropped"
+	// SortByDropRate orders the stages by drop rate.
+	SortByDropRate StatsSortKey = "drop-rate"
+)
+
 // Simulator represents a concurrent pipeline simulator that orchestrates
 // multiple processing stages in a data flow pipeline.
 type Simulator struct {
 	Duration time.Duration
-	stages   []*Stage
-	mu       sync.RWMutex
-	ctx      context.Context
-	cancel   context.CancelFunc
-	quit     chan struct{}
-	wg       sync.WaitGroup
+
+	// SortBy orders the console stats table, the Δ% columns still
+	// describe each stage's pipeline neighbor.
+	SortBy StatsSortKey
+
+	// SortDesc sorts the console stats table in descending order.
+	SortDesc bool
+
+	// Baseline names the stage every row's Δ% columns are compared
+	// against, when empty each stage is compared to its predecessor.
+	Baseline string
+
+	// Profile selects the instrumentation of the stages, the reports
+	// state which one produced the numbers.
+	Profile Profile
+
+	// SharedPoolSize runs every worker stage on one shared pool of this
+	// many goroutines instead of RoutineNum goroutines per stage, which
+	// still caps each stage's concurrency. Something like GOMAXPROCS × N
+	// fits large topologies, zero keeps per-stage goroutines. The blocked
+	// time is still reported per RoutineNum worker slot.
+	SharedPoolSize int
+
+	// Clock drives every sleep, timer and timestamp of the run,
+	// defaults to the real clock.
+	Clock Clock
+
+	// CheckInvariantsOnDone audits the run's accounting once it finishes
+	// and logs every violation.
+	CheckInvariantsOnDone bool
+
+	// ArtifactWorkers bounds how many output files are written at the
+	// same time at the end of a run, defaults to GOMAXPROCS.
+	ArtifactWorkers int
+
+	stages []*Stage
+	mu     sync.RWMutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	quit   chan struct{}
+	pool   *workerPool
+
+	// stepper schedules a synchronous run, nil unless stepping.
+	stepper *stepper
+
+	// calibration annotates the console report once MeasureOverhead ran.
+	calibration *Calibration
 }
 
 // NewSimulator creates a new simulator for a specific pipeline.
@@ -93,21 +152,40 @@ func (s *Simulator) Start(choice DataPresentationChoices) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if len(s.stages) < 3 {
-		return fmt.Errorf("no stages to run")
+	if s.stepper != nil {
+		return errors.New("simulation is stepping, use StopStepping")
+	}
+
+	if err := s.validateRun(); err != nil {
+		return err
+	}
+
+	if s.SharedPoolSize > 0 {
+		s.pool = newWorkerPool(s.SharedPoolSize)
 	}
 
 	if err := s.initializeStages(); err != nil {
+		// No stage started, nothing else would ever close the pool.
+		if s.pool != nil {
+			s.pool.close()
+			s.pool = nil
+		}
 		return fmt.Errorf("failed to initialize stages: %w", err)
 	}
 
 	go func() {
 		if s.Duration > 0 {
-			time.Sleep(s.Duration)
+			<-s.runClock().After(s.Duration)
 			s.stop()
 		}
 
-		s.wg.Wait()
+		for _, stage := range s.stages {
+			<-stage.done
+		}
+
+		if s.pool != nil {
+			s.pool.close()
+		}
 		close(s.quit)
 	}()
 
@@ -116,6 +194,58 @@ func (s *Simulator) Start(choice DataPresentationChoices) error {
 	return nil
 }
 
+// validateRun checks the simulator's settings before a run.
+func (s *Simulator) validateRun() error {
+	if len(s.stages) < 3 {
+		return fmt.Errorf("no stages to run")
+	}
+
+	if err := validateSortKey(s.SortBy); err != nil {
+		return err
+	}
+
+	if err := validateBaseline(s.stages, s.Baseline); err != nil {
+		return err
+	}
+
+	if s.Profile != DefaultProfile && s.Profile != FastProfile {
+		return fmt.Errorf("unknown profile: %d", s.Profile)
+	}
+
+	if s.SharedPoolSize < 0 {
+		return errors.New("shared pool size cannot be negative")
+	}
+
+	return nil
+}
+
+// Reset prepares a finished simulator for another run of the same
+// topology. Stages, their metrics, their goroutine trackers and their
+// configs are kept and zeroed in place instead of being rebuilt, only
+// the channels closed by the previous run are replaced.
+func (s *Simulator) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.quit:
+	default:
+		return errors.New("simulation has not finished, cannot reset")
+	}
+
+	s.cancel()
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.quit = make(chan struct{})
+	s.pool = nil
+	s.stepper = nil
+
+	for _, stage := range s.stages {
+		stage.reset()
+	}
+
+	return nil
+}
+
 // GetStages returns a copy of all stages in the pipeline.
 // Getter used by test package
 func (s *Simulator) GetStages() []*Stage {
@@ -124,6 +254,13 @@ func (s *Simulator) GetStages() []*Stage {
 	return s.stages
 }
 
+func (s *Simulator) runClock() Clock {
+	if s.Clock != nil {
+		return s.Clock
+	}
+	return RealClock{}
+}
+
 func (s *Simulator) stop() {
 	s.cancel()
 }
@@ -135,6 +272,8 @@ func (s *Simulator) done() <-chan struct{} {
 func (s *Simulator) waitForStats(choice DataPresentationChoices) {
 	<-s.done()
 
+	s.logInvariants()
+
 	switch choice {
 	case DotFiles:
 		err := s.WritePipelineDot(graphFileName)
@@ -147,28 +286,26 @@ func (s *Simulator) waitForStats(choice DataPresentationChoices) {
 
 }
 
-type stateEntry struct {
-	Stats map[tracker.GoroutineId]*tracker.GoroutineStats
-	Label string
-}
-
 func (s *Simulator) printStats() {
 	stages := s.GetStages()
-	printHeader()
+	rows := s.printStatsTable(stages)
 
-	var prev *stageStats
-	allStages := []*stateEntry{}
+	for _, row := range rows {
+		if row.stats.isGenerator {
+			printGenerationRate(&row.stats)
+		}
+	}
+
+	printFlowSummary(rows)
 
 	for _, stage := range stages {
-		current := collectStageStats(stage)
-		procDiff, thruDiff := computeDiffs(prev, &current)
-		printStageRow(&current, procDiff, thruDiff)
-		prev = &current
-		entry := &stateEntry{
-			Stats: stage.gm.GetAllStats(),
-			Label: stage.Name,
+		if stage.Config.LazyWorkers && !stage.isGenerator {
+			fmt.Printf("\n%s: routines %s\n", stage.Name, stage.routinesLabel())
 		}
-		allStages = append(allStages, entry)
+	}
+
+	if s.calibration != nil {
+		fmt.Printf("\nCalibration: %s\n", s.calibration)
 	}
 
 	println()
@@ -176,31 +313,66 @@ func (s *Simulator) printStats() {
 	fmt.Println("Goroutine Blocked Time Histogram")
 	fmt.Println("================================")
 
+	if s.stepper != nil {
+		fmt.Println("Blocked time doesn't apply in step mode")
+		return
+	}
+
+	if s.Profile == FastProfile {
+		fmt.Println("Blocked time isn't tracked by the fast profile")
+		return
+	}
+
+	// The tracker maps are fetched one stage at a time so only a single
+	// stage's goroutine stats are held in memory.
 	first := 0
 	last := len(stages) - 1
-	for i, item := range allStages {
+	for i, stage := range stages {
 		if i == first || i == last {
 			continue
 		}
-		tracker.PrintBlockedTimeHistogram(item.Stats, item.Label)
+		if note := stage.trackingNote(); note != "" {
+			fmt.Printf("%s: blocked time is approximate (%s)\n", stage.Name, note)
+		}
+		tracker.PrintBlockedTimeHistogram(stage.gm.GetAllStats(), stage.Name)
+	}
+}
+
+// printStatsTable prints the header and one row per stage in the
+// requested order, it returns the rows for the summaries below it.
+func (s *Simulator) printStatsTable(stages []*Stage) []*statsRow {
+	printHeader(s.Profile, s.Baseline)
+
+	rows := collectStatsRows(stages, s.Baseline)
+	sortStatsRows(rows, s.SortBy, s.SortDesc)
+	for _, row := range rows {
+		printStageRow(row.index, &row.stats, row.procDiff, row.thruDiff)
 	}
+
+	return rows
 }
 
 // WritePipelineDot generates a Graphviz DOT representation of the pipeline
-// and writes it to the given file path.
+// and writes it to the given file path, along with a blocked time
+// histogram for each worker stage. The files are written concurrently.
+// Histograms are named after their stage with spaces replaced by
+// underscores, nothing is written if two files would share a name.
 func (s *Simulator) WritePipelineDot(filename string) error {
-	var b strings.Builder
-
-	s.writeDotHeader(&b)
+	return s.writeArtifacts(filename)
+}
 
-	if err := s.writeDotNodes(&b); err != nil {
-		return err
-	}
+// WritePipelineDotTo streams the Graphviz DOT representation of the
+// pipeline to w, nodes and edges are written as they're generated.
+// Unlike WritePipelineDot it doesn't write the stage histograms.
+func (s *Simulator) WritePipelineDotTo(w io.Writer) error {
+	b := bufio.NewWriter(w)
 
-	s.writeDotEdges(&b)
-	s.writeDotFooter(&b)
+	s.writeDotHeader(b)
+	s.writeDotNodes(b)
+	s.writeDotEdges(b)
+	s.writeDotFooter(b)
 
-	return os.WriteFile(filename, []byte(b.String()), 0o644)
+	return b.Flush()
 }
 
 func (s *Simulator) initializeStages() error {
@@ -212,9 +384,12 @@ func (s *Simulator) initializeStages() error {
 	lastStage.isFinal = true
 
 	for i, stage := range s.stages {
-		stage.Config.ctx = s.ctx
-
-		s.wg.Add(stage.Config.RoutineNum)
+		stage.ctx = s.ctx
+		stage.profile = s.Profile
+		stage.clock = s.runClock()
+		if !stage.isGenerator {
+			stage.pool = s.pool
+		}
 
 		beforeLastStage := i < len(s.stages)-1
 		if beforeLastStage {
@@ -224,8 +399,12 @@ func (s *Simulator) initializeStages() error {
 		if err := stage.validateConfig(); err != nil {
 			return err
 		}
+	}
 
-		stage.initializeStage(&s.wg)
+	// Every stage is validated before any starts, so a bad config late in
+	// the pipeline doesn't leave earlier stages running.
+	for _, stage := range s.stages {
+		stage.initializeStage()
 	}
 
 	return nil
@@ -0,0 +1,145 @@
+package simulator_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator"
+	"github.com/AlexsanderHamir/GoFlow/simulator/testkit"
+)
+
+func TestResetBeforeFinishFails(t *testing.T) {
+	sim := testkit.NewPipeline(t, testkit.PipelineOptions{Workers: 1, BufferSize: 1})
+	if err := sim.Reset(); err == nil {
+		t.Error("Expected Reset to fail before the run finished")
+	}
+}
+
+func TestResetClearsState(t *testing.T) {
+	sim := testkit.NewPipeline(t, testkit.PipelineOptions{Workers: 2, RoutineNum: 2, BufferSize: 4})
+	testkit.RunFor(t, sim, 30*time.Millisecond)
+
+	managers := make(map[string]any)
+	for _, stage := range sim.GetStages() {
+		managers[stage.Name] = stage.Tracker()
+	}
+
+	if err := sim.Reset(); err != nil {
+		t.Fatalf("Failed to reset: %v", err)
+	}
+
+	for _, stage := range sim.GetStages() {
+		if counts := stage.GetCounts(); counts != (simulator.StageCounts{}) {
+			t.Errorf("%s: expected zeroed counts after Reset, got %+v", stage.Name, counts)
+		}
+
+		if stats := stage.Tracker().GetAllStats(); len(stats) != 0 {
+			t.Errorf("%s: expected no goroutine stats after Reset, got %d", stage.Name, len(stats))
+		}
+
+		if managers[stage.Name] != any(stage.Tracker()) {
+			t.Errorf("%s: expected the tracker manager to be recycled", stage.Name)
+		}
+
+		if stage.WorkersStarted() != 0 || stage.Active() != 0 {
+			t.Errorf("%s: expected no workers after Reset, got %d started, %d active",
+				stage.Name, stage.WorkersStarted(), stage.Active())
+		}
+	}
+}
+
+func TestResetRunsDoNotBleed(t *testing.T) {
+	for _, poolSize := range []int{0, 4} {
+		t.Run(fmt.Sprintf("pool=%d", poolSize), func(t *testing.T) {
+			sim := testkit.NewPipeline(t, testkit.PipelineOptions{
+				Workers:    2,
+				RoutineNum: 2,
+				BufferSize: 4,
+				InputRate:  time.Millisecond,
+			})
+			sim.SharedPoolSize = poolSize
+
+			for run := range 3 {
+				if run > 0 {
+					if err := sim.Reset(); err != nil {
+						t.Fatalf("Failed to reset before run %d: %v", run, err)
+					}
+				}
+
+				testkit.RunFor(t, sim, 50*time.Millisecond)
+				testkit.AssertAccounting(t, sim)
+
+				// Paced at one item per millisecond, a run can't generate more
+				// than its own window's worth, earlier runs' counts would show.
+				generated := sim.GetStages()[0].GetCounts().Generated
+				if generated == 0 || generated > 100 {
+					t.Errorf("Run %d: expected at most 100 generated items from a 50ms run, got %d", run, generated)
+				}
+
+				for _, stage := range sim.GetStages()[1:] {
+					if stats := stage.Tracker().GetAllStats(); len(stats) > stage.Config.RoutineNum {
+						t.Errorf("Run %d: %s tracks %d goroutines, expected at most %d",
+							run, stage.Name, len(stats), stage.Config.RoutineNum)
+					}
+				}
+			}
+		})
+	}
+}
+
+// runOnce runs sim for d without testkit.RunFor's leak check, which
+// would outweigh the short runs being measured.
+func runOnce(b *testing.B, sim *simulator.Simulator, d time.Duration) {
+	sim.Duration = d
+	if err := sim.Start(simulator.Nothing); err != nil {
+		b.Fatalf("Failed to run the simulation: %v", err)
+	}
+}
+
+// BenchmarkSequentialRuns compares running the same topology again with
+// Reset against rebuilding the simulator for every run.
+func BenchmarkSequentialRuns(b *testing.B) {
+	opts := testkit.PipelineOptions{Workers: 4, RoutineNum: 4, BufferSize: 64}
+	const run = time.Millisecond
+
+	b.Run("reset", func(b *testing.B) {
+		b.ReportAllocs()
+		sim := testkit.NewPipeline(b, opts)
+		runOnce(b, sim, run)
+
+		b.ResetTimer()
+		for range b.N {
+			if err := sim.Reset(); err != nil {
+				b.Fatal(err)
+			}
+			runOnce(b, sim, run)
+		}
+	})
+
+	b.Run("rebuild", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			runOnce(b, testkit.NewPipeline(b, opts), run)
+		}
+	})
+}
+
+// BenchmarkPipelineProfile is a short unpaced run of a four stage
+// pipeline under each profile, reporting the items the sink consumed.
+func BenchmarkPipelineProfile(b *testing.B) {
+	for _, profile := range []simulator.Profile{simulator.DefaultProfile, simulator.FastProfile} {
+		b.Run(profile.String(), func(b *testing.B) {
+			var consumed uint64
+			for range b.N {
+				sim := testkit.NewPipeline(b, testkit.PipelineOptions{Workers: 4, RoutineNum: 4, BufferSize: 64})
+				sim.Profile = profile
+				runOnce(b, sim, 50*time.Millisecond)
+
+				stages := sim.GetStages()
+				consumed += stages[len(stages)-1].GetCounts().Consumed
+			}
+			b.ReportMetric(float64(consumed)/b.Elapsed().Seconds(), "items/s")
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package simulator
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/AlexsanderHamir/IdleSpy/tracker"
@@ -13,9 +17,12 @@ type Stage struct {
 	Name   string
 	Config *StageConfig
 
+	// Context for cancellation and deadlines, kept on the stage since
+	// the config can be shared among stages.
+	ctx context.Context
+
 	input  chan any
 	output chan any
-	sem    chan struct{}
 
 	metrics *stageMetrics
 
@@ -24,7 +31,55 @@ type Stage struct {
 
 	stop func()
 
-	gm *tracker.GoroutineManager
+	gm     *tracker.GoroutineManager
+	clock  Clock
+	coarse *coarseClock
+
+	// pacer is shared by all generator goroutines so the aggregate
+	// generation rate matches InputRate regardless of RoutineNum.
+	pacer Ticker
+
+	// profile is the simulator's instrumentation profile for this run.
+	profile Profile
+
+	// stepping is set when the simulator drives the stage synchronously.
+	stepping bool
+
+	// pool runs the stage's items when the simulator shares one worker
+	// pool across stages. slots caps the stage's concurrency in the
+	// pool and inflight tracks the items it's running.
+	pool     *workerPool
+	slots    chan int
+	inflight sync.WaitGroup
+
+	// outputs holds each tracked worker's backpressure sampler by slot,
+	// so a blocked send is timed for the goroutine waiting on it. It's
+	// nil when nothing is tracked. samplers holds a pooled slot's idle
+	// sampler, its equivalent of the input waits. Whichever pool
+	// goroutine runs the slot's item uses the slot's samplers.
+	outputs  []*waitSampler
+	samplers []*waitSampler
+
+	// done is closed once the stage closed its output.
+	done chan struct{}
+
+	// active counts the stage's running goroutines, the last one to
+	// exit finalizes the stage. ended collects the tracked goroutines
+	// for the batched tracker finalization.
+	active atomic.Int64
+	ended  []endedGoroutine
+
+	// started and exited count every goroutine the stage launched, a
+	// goroutine's exit is its very last action, after it released the
+	// stage. VerifyClean compares them, unlike active they don't depend
+	// on the stage having finished.
+	started atomic.Int64
+	exited  atomic.Int64
+
+	// workersStarted is the high-water worker count, workers never exit
+	// before the stage ends. spawnEvents counts the lazy spawns.
+	workersStarted atomic.Int64
+	spawnEvents    atomic.Uint64
 }
 
 // GetIsGenerator is a getter.
@@ -42,69 +97,155 @@ func NewStage(name string, config *StageConfig) *Stage {
 		Name:    name,
 		output:  make(chan any, config.BufferSize),
 		Config:  config,
-		sem:     make(chan struct{}, 1),
+		done:    make(chan struct{}),
 		metrics: newStageMetrics(),
 		gm:      tracker.NewGoroutineManager(),
+		clock:   RealClock{},
 	}
 }
 
+// reset clears the state left by a previous run so the stage can be
+// started again. The output channel is replaced since the run closed it
+// to stop the next stage, a closed channel can't be reopened, and the
+// items it still buffered belonged to the finished run. The tracker
+// manager is recycled, it has no way to clear its stats so it's set to
+// a new manager's state, lock included, rather than cleared unlocked.
+func (s *Stage) reset() {
+	s.output = make(chan any, s.Config.BufferSize)
+	s.done = make(chan struct{})
+	s.stepping = false
+	s.workersStarted.Store(0)
+	s.spawnEvents.Store(0)
+	s.started.Store(0)
+	s.exited.Store(0)
+	s.input = nil
+	s.metrics.reset()
+	*s.gm = *tracker.NewGoroutineManager()
+	s.coarse = nil
+	s.pacer = nil
+	s.pool = nil
+	s.outputs = nil
+	s.samplers = nil
+}
+
 // generatorWorker is the worker for the generators
-func (s *Stage) generatorWorker(wg *sync.WaitGroup) {
-	defer s.stageTermination(wg)
+func (s *Stage) generatorWorker(slot int) {
+	defer s.release()
 
 	for {
 		select {
-		case <-s.Config.ctx.Done():
+		case <-s.ctx.Done():
 			return
 		default:
-			s.handleGeneration()
+			s.handleGeneration(slot)
 		}
 	}
 }
 
-// worker is the worker for normal stages
-func (s *Stage) worker(wg *sync.WaitGroup) {
-	id := s.gm.TrackGoroutineStart()
+// worker is the worker for normal stages, slot is the worker's
+// metric shard when sharding is enabled.
+func (s *Stage) worker(slot int) {
+	id := s.trackStart()
+	sampler := newWaitSampler(s, id)
+	output := newOutputSampler(s, id)
+	s.outputs[slot] = output
 
 	defer func() {
-		s.stageTermination(wg)
-		s.gm.TrackGoroutineEnd(id)
+		sampler.flush()
+		output.flush()
+		s.exit(slot, id)
 	}()
 
 	for {
-		startTime := time.Now()
+		sampler.begin()
+
 		select {
-		case <-s.Config.ctx.Done():
+		case <-s.ctx.Done():
 			return
 		case item, ok := <-s.input:
-			latency := time.Since(startTime)
-			s.gm.TrackSelectCase(s.Name, latency, id)
+			sampler.end()
 			if !ok {
 				return
 			}
+			s.metrics.recordReceived(slot)
+			s.handleItem(item, slot)
+		}
+	}
+}
 
-			if !s.isFinal {
-				result, err := s.processItem(item)
-				if err != nil {
-					s.metrics.recordDropped()
-					break
-				}
-				s.metrics.recordProcessed()
+// handleItem processes and forwards a received item, the sink only
+// consumes it.
+func (s *Stage) handleItem(item any, slot int) {
+	if s.isFinal {
+		s.metrics.recordConsumed(slot)
+		return
+	}
 
-				s.sendOutput(result)
-				break
-			}
+	result, err := s.processItem(item)
+	switch {
+	case errors.Is(err, ErrFiltered):
+		s.metrics.recordFiltered(slot)
+		return
+	case err != nil:
+		s.metrics.recordDropped(slot)
+		return
+	}
+	s.metrics.recordProcessed(slot)
 
-			s.metrics.recordDropped()
-		}
+	s.sendOutput(result, slot)
+}
+
+// now returns the time used for select-case attribution, the coarse
+// clock when one is configured.
+func (s *Stage) now() time.Time {
+	if s.coarse != nil {
+		return s.coarse.Now()
+	}
+	return s.clock.Now()
+}
+
+// sampleRate returns how many select-case waits share one recorded sample.
+func (s *Stage) sampleRate() int {
+	if s.Config.TrackingSampleRate > 1 {
+		return s.Config.TrackingSampleRate
 	}
+	return 1
+}
+
+// trackingNote describes how the blocked time was measured when it isn't
+// exact, it's empty for the default mode.
+func (s *Stage) trackingNote() string {
+	if s.profile == FastProfile || s.stepping {
+		return ""
+	}
+
+	var notes []string
+	if rate := s.sampleRate(); rate > 1 {
+		notes = append(notes, fmt.Sprintf("sampled 1/%d waits, totals scaled x%d", rate, rate))
+	}
+
+	if s.Config.TrackingResolution > 0 {
+		notes = append(notes, fmt.Sprintf("clock resolution %s", s.Config.TrackingResolution))
+	}
+
+	if evicted := s.metrics.loadEvictions(); evicted > 0 {
+		notes = append(notes, fmt.Sprintf("percentiles exclude %d waits past the %d per goroutine cap", evicted, s.Config.TrackingMaxRecords))
+	}
+
+	return strings.Join(notes, ", ")
 }
 
 // processRegularGeneration handles the regular item generation flow
-func (s *Stage) handleGeneration() {
+func (s *Stage) handleGeneration(slot int) {
+	generated := false
 	defer func() {
 		if r := recover(); r != nil {
-			s.metrics.recordDropped()
+			// An item lost to a panicking generator still counts as
+			// generated, so generated = output + dropped holds.
+			if !generated {
+				s.metrics.recordGenerated(slot)
+			}
+			s.metrics.recordDropped(slot)
 		}
 	}()
 
@@ -112,50 +253,102 @@ func (s *Stage) handleGeneration() {
 		return
 	}
 
-	if s.Config.InputRate > 0 {
-		time.Sleep(s.Config.InputRate)
+	if !s.waitForTurn() {
+		return
 	}
 
 	item := s.Config.ItemGenerator()
-	s.metrics.recordGenerated()
+	s.metrics.recordGenerated(slot)
+	generated = true
 
-	select {
-	case <-s.Config.ctx.Done():
-		s.metrics.recordDropped()
-	case s.output <- item: // blocks
-		s.metrics.recordOutput()
-	default:
-		if s.Config.DropOnBackpressure {
-			s.metrics.recordDropped()
-		} else {
-			s.output <- item
-			s.metrics.recordOutput()
+	s.sendOutput(item, slot)
+}
+
+// waitForTurn paces the generation, it returns false if the simulation
+// ended while waiting.
+func (s *Stage) waitForTurn() bool {
+	switch {
+	case s.pacer != nil:
+		select {
+		case <-s.ctx.Done():
+			return false
+		case <-s.pacer.C():
 		}
+	case s.Config.InputRate > 0:
+		s.clock.Sleep(s.Config.InputRate)
 	}
+
+	return true
 }
 
-// handleWorkerOutput manages sending the processed item to the output channel with backpressure.
-func (s *Stage) sendOutput(result any) {
-	defer func() {
-		if r := recover(); r != nil {
-			s.metrics.recordDropped()
-		}
-	}()
+// offeredRate returns the configured aggregate generation rate in items
+// per second, zero when generation isn't paced.
+func (s *Stage) offeredRate() float64 {
+	if s.Config.InputRate <= 0 {
+		return 0
+	}
+
+	rate := float64(time.Second) / float64(s.Config.InputRate)
+	if s.Config.PerWorkerRate {
+		rate *= float64(s.Config.RoutineNum)
+	}
 
+	return rate
+}
+
+// handleWorkerOutput manages sending the processed item to the output channel with backpressure.
+func (s *Stage) sendOutput(result any, slot int) {
 	select {
-	case <-s.Config.ctx.Done():
-		s.metrics.recordDropped()
+	case <-s.ctx.Done():
+		s.metrics.recordInFlight(slot)
 		return
 	case s.output <- result:
-		s.metrics.recordOutput()
+		s.metrics.recordOutput(slot)
+		return
 	default:
-		if s.Config.DropOnBackpressure {
-			s.metrics.recordDropped()
-		} else {
-			s.output <- result // blocks
-			s.metrics.recordOutput()
-		}
 	}
+
+	if s.Config.DropOnBackpressure {
+		s.metrics.recordDropped(slot)
+		return
+	}
+
+	// Blocks until there's room, or the simulation ends since the next
+	// stage may have already stopped reading. The wait is the worker's
+	// blocked time, under its own select case.
+	sampler := s.outputSampler(slot)
+	if sampler != nil {
+		sampler.begin()
+	}
+
+	select {
+	case <-s.ctx.Done():
+		s.metrics.recordInFlight(slot)
+	case s.output <- result:
+		s.metrics.recordOutput(slot)
+	}
+
+	if sampler != nil {
+		sampler.end()
+	}
+}
+
+// outputSampler returns the backpressure sampler of the worker on slot,
+// nil when the stage isn't tracked.
+func (s *Stage) outputSampler(slot int) *waitSampler {
+	if s.outputs == nil {
+		return nil
+	}
+	return s.outputs[slot]
+}
+
+// sampler returns the idle sampler of a pooled slot, nil when the stage
+// isn't tracked.
+func (s *Stage) sampler(slot int) *waitSampler {
+	if s.samplers == nil {
+		return nil
+	}
+	return s.samplers[slot]
 }
 
 func (s *Stage) validateConfig() error {
@@ -181,11 +374,41 @@ func (s *Stage) validateConfig() error {
 		return errors.New("input rate cannot be negative for generator stages")
 	}
 
+	if cfg.TrackingResolution < 0 {
+		return errors.New("tracking resolution cannot be negative")
+	}
+
+	if cfg.TrackingSampleRate < 0 {
+		return errors.New("tracking sample rate cannot be negative")
+	}
+
+	if cfg.CoreWorkers < 0 {
+		return errors.New("core workers cannot be negative")
+	}
+
+	if cfg.SpawnThreshold < 0 || cfg.SpawnThreshold > 1 {
+		return errors.New("spawn threshold must be between 0 and 1")
+	}
+
+	if cfg.SpawnInterval < 0 {
+		return errors.New("spawn interval cannot be negative")
+	}
+
+	// Congestion is read off the input buffer, an unbuffered input would
+	// never spawn a worker past the core ones.
+	if cfg.LazyWorkers && s.lazyEligible() && cap(s.input) == 0 {
+		return errors.New("lazy workers need a buffered input, set BufferSize on the previous stage")
+	}
+
+	if cfg.TrackingMaxRecords < 0 {
+		return errors.New("tracking max records cannot be negative")
+	}
+
 	if cfg.RetryCount < 0 {
 		return errors.New("retry count cannot be negative")
 	}
 
-	if cfg.ctx == nil {
+	if s.ctx == nil {
 		return errors.New("context must not be nil")
 	}
 
@@ -196,45 +419,91 @@ func (s *Stage) validateConfig() error {
 	return nil
 }
 
-func (s *Stage) initializeStage(wg *sync.WaitGroup) {
+func (s *Stage) initializeStage() {
+	s.metrics.start(s.clock)
+	if len(s.ended) == s.Config.RoutineNum {
+		clear(s.ended)
+	} else {
+		s.ended = make([]endedGoroutine, s.Config.RoutineNum)
+	}
+
+	if s.Config.ShardedMetrics {
+		s.metrics.enableShards(s.Config.RoutineNum)
+	} else {
+		s.metrics.shards = nil
+	}
+
+	if s.Config.TrackingResolution > 0 && !s.isGenerator && s.profile != FastProfile {
+		s.coarse = newCoarseClock(s.clock, s.Config.TrackingResolution, s.spawn)
+	}
+
+	s.outputs, s.samplers = nil, nil
+	if !s.isGenerator && s.profile != FastProfile {
+		s.outputs = make([]*waitSampler, s.Config.RoutineNum)
+	}
+
+	if s.isGenerator && s.Config.InputRate > 0 && !s.Config.PerWorkerRate {
+		s.pacer = s.clock.NewTicker(s.Config.InputRate)
+	}
+
 	if s.isGenerator {
-		s.initializeGenerators(wg)
+		s.initializeGenerators()
 	} else {
-		s.initializeWorkers(wg)
+		s.initializeWorkers()
 	}
 }
 
-func (s *Stage) initializeGenerators(wg *sync.WaitGroup) {
-	for range s.Config.RoutineNum {
-		go s.generatorWorker(wg)
+func (s *Stage) initializeGenerators() {
+	s.retain(s.Config.RoutineNum)
+	for slot := range s.Config.RoutineNum {
+		s.spawn(func() { s.generatorWorker(slot) })
 	}
+	s.workersStarted.Store(int64(s.Config.RoutineNum))
 }
 
-func (s *Stage) initializeWorkers(wg *sync.WaitGroup) {
+func (s *Stage) initializeWorkers() {
+	if s.pool != nil {
+		s.initializePooledStage()
+		return
+	}
+
+	if s.Config.LazyWorkers {
+		s.initializeLazyWorkers()
+		return
+	}
+
+	s.retain(s.Config.RoutineNum)
 	for range s.Config.RoutineNum {
-		go s.worker(wg)
+		s.startWorker()
 	}
 }
 
+// ErrFiltered is returned by a WorkerFunc, possibly wrapped, to filter
+// the item out on purpose. The item isn't retried and counts as
+// filtered rather than dropped.
+var ErrFiltered = errors.New("item filtered")
+
 // processItem handles a single item with retries and delay if configured
 func (s *Stage) processItem(item any) (any, error) {
 	var lastErr error
 	attempt := 0
 
 	for {
-		if s.Config.WorkerDelay > 0 {
-			time.Sleep(s.Config.WorkerDelay)
+		if s.Config.WorkerDelay > 0 && !s.stepping {
+			s.clock.Sleep(s.Config.WorkerDelay)
 		}
 
 		result, err := s.Config.WorkerFunc(item)
-		if err == nil {
-			return result, nil
+		if err == nil || errors.Is(err, ErrFiltered) {
+			return result, err
 		}
 
 		lastErr = err
 		attempt++
 
-		if attempt == s.Config.RetryCount {
+		// The first attempt isn't a retry, a zero RetryCount must not
+		// retry forever.
+		if attempt > s.Config.RetryCount {
 			break
 		}
 	}
@@ -242,23 +511,52 @@ func (s *Stage) processItem(item any) (any, error) {
 	return nil, lastErr
 }
 
+// StageCounts is a snapshot of a stage's item counters.
+type StageCounts struct {
+	Generated uint64
+	Received  uint64
+	Processed uint64
+	Output    uint64
+
+	// Items the stage discarded: dropped on backpressure, failed by the
+	// worker function or lost to a panicking generator, which counts
+	// them as generated too. Consumed and in flight items aren't drops,
+	// they used to be counted here.
+	Dropped uint64
+
+	// Items the sink received, the end of their journey.
+	Consumed uint64
+
+	// Items the worker function filtered out by returning ErrFiltered.
+	Filtered uint64
+
+	// Items a goroutine was still holding when the simulation was
+	// stopped, they're neither output nor dropped.
+	InFlight uint64
+
+	// Items left in the stage's output buffer, sent but never received
+	// by the next stage.
+	Buffered int
+}
+
+// GetCounts returns a snapshot of the stage's item counters.
+// Used by the testkit package
+func (s *Stage) GetCounts() StageCounts {
+	return StageCounts{
+		Generated: s.metrics.loadGenerated(),
+		Received:  s.metrics.loadReceived(),
+		Processed: s.metrics.loadProcessed(),
+		Output:    s.metrics.loadOutput(),
+		Dropped:   s.metrics.loadDropped(),
+		Consumed:  s.metrics.loadConsumed(),
+		Filtered:  s.metrics.loadFiltered(),
+		InFlight:  s.metrics.loadInFlight(),
+		Buffered:  len(s.output),
+	}
+}
+
 // GetMetrics is a getting.
 // Used by the test package
 func (s *Stage) GetMetrics() *stageMetrics {
 	return s.metrics
 }
-
-// Only one worker will be able to close the channel and to
-// stop the metric, all other workers will just decrement the counter.
-func (s *Stage) stageTermination(wg *sync.WaitGroup) {
-	// Instead of calling wg.Done() inside case and default, it was best
-	// to do it outside the select.
-
-	select {
-	case s.sem <- struct{}{}:
-		close(s.output)
-		s.metrics.stop()
-	default:
-	}
-	wg.Done()
-}
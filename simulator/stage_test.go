@@ -0,0 +1,66 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOfferedRateIgnoresRoutines(t *testing.T) {
+	for _, routines := range []int{1, 10, 100} {
+		stage := NewStage("Generator", &StageConfig{RoutineNum: routines, InputRate: time.Millisecond})
+		if offered := stage.offeredRate(); offered != 1000 {
+			t.Errorf("Expected an offered rate of 1000 items/s with %d routines, got %.2f", routines, offered)
+		}
+	}
+}
+
+func TestPerWorkerRateScalesWithRoutines(t *testing.T) {
+	stage := NewStage("Generator", &StageConfig{RoutineNum: 10, InputRate: time.Millisecond, PerWorkerRate: true})
+	if offered := stage.offeredRate(); offered != 10000 {
+		t.Errorf("Expected an offered rate of 10000 items/s, got %.2f", offered)
+	}
+}
+
+func TestRetryCountIsRetriesAfterTheFirstCall(t *testing.T) {
+	errFail := errors.New("fail")
+
+	for _, tt := range []struct {
+		retries  int
+		failures int
+		calls    int
+		ok       bool
+	}{
+		{retries: 0, failures: 100, calls: 1},
+		{retries: 1, failures: 100, calls: 2},
+		{retries: 2, failures: 100, calls: 3},
+		{retries: 2, failures: 2, calls: 3, ok: true},
+		{retries: 2, failures: 0, calls: 1, ok: true},
+	} {
+		t.Run(fmt.Sprintf("retries=%d/failures=%d", tt.retries, tt.failures), func(t *testing.T) {
+			calls := 0
+			stage := NewStage("Retry", &StageConfig{
+				RoutineNum: 1,
+				RetryCount: tt.retries,
+				WorkerFunc: func(item any) (any, error) {
+					calls++
+					if calls <= tt.failures {
+						return nil, errFail
+					}
+					return item, nil
+				},
+			})
+			stage.ctx = context.Background()
+
+			_, err := stage.processItem(1)
+			if (err == nil) != tt.ok {
+				t.Errorf("Expected success %v, got error %v", tt.ok, err)
+			}
+			if calls != tt.calls {
+				t.Errorf("Expected %d calls, got %d", tt.calls, calls)
+			}
+		})
+	}
+}
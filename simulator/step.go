@@ -0,0 +1,326 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EventKind is the outcome of a single step.
+type EventKind int
+
+const (
+	// EventGenerated is an item the generator sent downstream.
+	EventGenerated EventKind = iota
+	// EventForwarded is an item a worker stage processed and sent downstream.
+	EventForwarded
+	// EventFailed is an item dropped because the worker function failed.
+	EventFailed
+	// EventDropped is an item dropped on backpressure, or by a panicking
+	// item generator.
+	EventDropped
+	// EventConsumed is an item the sink received.
+	EventConsumed
+	// EventFiltered is an item the worker function filtered out.
+	EventFiltered
+)
+
+// String returns the kind's lowercase name, as Event.String prints it.
+func (k EventKind) String() string {
+	switch k {
+	case EventGenerated:
+		return "generated"
+	case EventForwarded:
+		return "forwarded"
+	case EventFailed:
+		return "failed"
+	case EventDropped:
+		return "dropped"
+	case EventConsumed:
+		return "consumed"
+	case EventFiltered:
+		return "filtered"
+	default:
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+}
+
+// Event describes what a single step did.
+type Event struct {
+	// Seq numbers the events of a run from 1.
+	Seq   uint64
+	Stage string
+	Kind  EventKind
+
+	// Item is the item as it left the stage, the received item when it
+	// was dropped or consumed.
+	Item any
+
+	// Err is the worker function's error for EventFailed.
+	Err error
+}
+
+// String formats the event as "seq stage kind item", followed by the
+// error for a failed item. Two runs with the same event strings took
+// the same steps.
+func (e Event) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s %s %v: %v", e.Seq, e.Stage, e.Kind, e.Item, e.Err)
+	}
+	return fmt.Sprintf("%d %s %s %v", e.Seq, e.Stage, e.Kind, e.Item)
+}
+
+// stepper is the single logical scheduler of a stepped run. Stages take
+// turns in pipeline order, each turn lasting up to RoutineNum steps so a
+// stage's routines still set its capacity relative to its neighbors.
+type stepper struct {
+	seq   uint64
+	stage int
+	turns int
+}
+
+// StartStepping prepares the pipeline for synchronous execution driven by
+// Step, no goroutines are started. Worker delays aren't slept, the pool
+// and lazy workers are ignored and an unbuffered stage holds one item.
+// With a deterministic generator and worker functions, the event sequence
+// is the same on every run. Blocked time isn't tracked, the reports say
+// it doesn't apply.
+func (s *Simulator) StartStepping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stepper != nil {
+		return errors.New("simulation is already stepping")
+	}
+
+	if err := s.validateRun(); err != nil {
+		return err
+	}
+
+	if err := s.initializeSteppedStages(); err != nil {
+		return fmt.Errorf("failed to initialize stages: %w", err)
+	}
+
+	s.stepper = &stepper{}
+	return nil
+}
+
+// Step advances the pipeline by exactly one event and returns it.
+func (s *Simulator) Step() (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.step()
+}
+
+// StepUntil steps until cond holds for an event and returns that event,
+// it fails once maxSteps events went by without cond holding. cond runs
+// without the simulator's lock held, so it may inspect the simulator.
+func (s *Simulator) StepUntil(cond func(Event) bool, maxSteps int) (Event, error) {
+	if maxSteps <= 0 {
+		return Event{}, errors.New("max steps must be greater than 0")
+	}
+
+	var event Event
+	for range maxSteps {
+		var err error
+		if event, err = s.Step(); err != nil {
+			return event, err
+		}
+
+		if cond(event) {
+			return event, nil
+		}
+	}
+
+	return event, fmt.Errorf("condition not met within %d steps", maxSteps)
+}
+
+// StopStepping ends a stepped run the way a timed run ends, so the
+// reports, CheckInvariants and Reset work as usual.
+func (s *Simulator) StopStepping(choice DataPresentationChoices) error {
+	s.mu.Lock()
+	if s.stepper == nil {
+		s.mu.Unlock()
+		return errors.New("simulation is not stepping")
+	}
+
+	select {
+	case <-s.quit:
+		s.mu.Unlock()
+		return errors.New("simulation has finished")
+	default:
+	}
+
+	s.stop()
+	for _, stage := range s.stages {
+		close(stage.output)
+		stage.metrics.stop()
+		close(stage.done)
+	}
+	close(s.quit)
+	s.mu.Unlock()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.waitForStats(choice)
+
+	return nil
+}
+
+func (s *Simulator) step() (Event, error) {
+	p := s.stepper
+	if p == nil {
+		return Event{}, errors.New("simulation is not stepping")
+	}
+
+	select {
+	case <-s.quit:
+		return Event{}, errors.New("simulation has finished")
+	default:
+	}
+
+	// The sink can always take an item, so some stage can step whenever
+	// one is blocked, at most one full round is scanned.
+	for range len(s.stages) + 1 {
+		stage := s.stages[p.stage]
+		if p.turns < stage.Config.RoutineNum && stage.canStep() {
+			p.turns++
+			p.seq++
+
+			event := stage.stepOnce()
+			event.Seq = p.seq
+			return event, nil
+		}
+
+		p.stage = (p.stage + 1) % len(s.stages)
+		p.turns = 0
+	}
+
+	return Event{}, errors.New("no stage can step")
+}
+
+func (s *Simulator) initializeSteppedStages() error {
+	generator := s.stages[0]
+	generator.stop = s.stop
+	generator.isGenerator = true
+
+	lastStage := s.stages[len(s.stages)-1]
+	lastStage.isFinal = true
+
+	for i, stage := range s.stages {
+		stage.ctx = s.ctx
+		stage.profile = s.Profile
+		stage.clock = s.runClock()
+		stage.stepping = true
+
+		// A handoff can't happen on an unbuffered channel with a single
+		// goroutine, the item waits in a one-slot buffer instead.
+		if cap(stage.output) == 0 {
+			stage.output = make(chan any, 1)
+		}
+
+		if i > 0 {
+			stage.input = s.stages[i-1].output
+		}
+
+		if err := stage.validateConfig(); err != nil {
+			return err
+		}
+
+		stage.metrics.start(stage.clock)
+		if stage.Config.ShardedMetrics {
+			stage.metrics.enableShards(1)
+		} else {
+			stage.metrics.shards = nil
+		}
+	}
+
+	return nil
+}
+
+// canStep reports whether the stage has an item to take and somewhere to
+// put the result without blocking.
+func (s *Stage) canStep() bool {
+	if !s.isGenerator && len(s.input) == 0 {
+		return false
+	}
+
+	return s.isFinal || s.Config.DropOnBackpressure || len(s.output) < cap(s.output)
+}
+
+// stepOnce moves one item through the stage with the same accounting as
+// the goroutine workers, canStep must hold.
+func (s *Stage) stepOnce() Event {
+	if s.isGenerator {
+		return s.stepGeneration()
+	}
+
+	event := Event{Stage: s.Name, Item: <-s.input}
+	s.metrics.recordReceived(0)
+
+	if s.isFinal {
+		s.metrics.recordConsumed(0)
+		event.Kind = EventConsumed
+		return event
+	}
+
+	result, err := s.processItem(event.Item)
+	if errors.Is(err, ErrFiltered) {
+		s.metrics.recordFiltered(0)
+		event.Kind = EventFiltered
+		return event
+	}
+	if err != nil {
+		s.metrics.recordDropped(0)
+		event.Kind = EventFailed
+		event.Err = err
+		return event
+	}
+	s.metrics.recordProcessed(0)
+
+	event.Kind = EventForwarded
+	if !s.offer(result) {
+		event.Kind = EventDropped
+	}
+	event.Item = result
+
+	return event
+}
+
+func (s *Stage) stepGeneration() (event Event) {
+	event = Event{Stage: s.Name, Kind: EventDropped}
+
+	generated := false
+	defer func() {
+		if r := recover(); r != nil {
+			if !generated {
+				s.metrics.recordGenerated(0)
+			}
+			s.metrics.recordDropped(0)
+			event.Kind = EventDropped
+		}
+	}()
+
+	event.Item = s.Config.ItemGenerator()
+	s.metrics.recordGenerated(0)
+	generated = true
+
+	if s.offer(event.Item) {
+		event.Kind = EventGenerated
+	}
+
+	return event
+}
+
+// offer sends the item downstream without blocking, it's dropped when
+// the output is full.
+func (s *Stage) offer(item any) bool {
+	select {
+	case s.output <- item:
+		s.metrics.recordOutput(0)
+		return true
+	default:
+		s.metrics.recordDropped(0)
+		return false
+	}
+}
@@ -0,0 +1,150 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AlexsanderHamir/IdleSpy/tracker"
+)
+
+// endedGoroutine is a tracked goroutine waiting for the batched tracker
+// finalization, indexed by the goroutine's slot.
+type endedGoroutine struct {
+	id      tracker.GoroutineId
+	tracked bool
+}
+
+// retain counts n goroutines about to start, it must happen before they
+// can exit.
+func (s *Stage) retain(n int) {
+	s.active.Add(int64(n))
+}
+
+// spawn starts fn on a goroutine the stage accounts for, its exit is
+// recorded once fn and everything it deferred returned.
+func (s *Stage) spawn(fn func()) {
+	s.started.Add(1)
+	go func() {
+		defer s.exited.Add(1)
+		fn()
+	}()
+}
+
+// trackStart registers the calling goroutine with the tracker.
+func (s *Stage) trackStart() tracker.GoroutineId {
+	return s.gm.TrackGoroutineStart()
+}
+
+// exit records a tracked goroutine leaving the stage and releases it.
+// Each slot belongs to a single goroutine, so the write needs no lock,
+// the atomic decrement in release publishes it to the last goroutine.
+func (s *Stage) exit(slot int, id tracker.GoroutineId) {
+	s.ended[slot] = endedGoroutine{id: id, tracked: true}
+	s.release()
+}
+
+// release is the O(1) exit of every stage goroutine, the last one out
+// finalizes the stage.
+func (s *Stage) release() {
+	if s.active.Add(-1) == 0 {
+		s.finalize()
+	}
+}
+
+// finalize ends the tracking of every goroutine in one pass, closes the
+// output so the next stage drains and stops, and marks the stage done.
+// Only the last goroutine runs it, so nobody can still send on the output.
+func (s *Stage) finalize() {
+	for _, ended := range s.ended {
+		if ended.tracked {
+			s.gm.TrackGoroutineEnd(ended.id)
+		}
+	}
+
+	close(s.output)
+	s.metrics.stop()
+	if s.coarse != nil {
+		s.coarse.stop()
+	}
+	if s.pacer != nil {
+		s.pacer.Stop()
+	}
+	close(s.done)
+}
+
+// cleanGrace is how long VerifyClean gives goroutines that already
+// finished their work to return.
+var cleanGrace = time.Second
+
+// VerifyClean checks that a finished run left nothing behind: every
+// goroutine the stages and the shared pool started returned, and every
+// goroutine the tracker saw was ended. Goroutines are counted as they
+// start and as their last action, so one still running after releasing
+// its stage is caught. The error names each offending stage.
+func (s *Simulator) VerifyClean() error {
+	select {
+	case <-s.done():
+	default:
+		return errors.New("simulation has not finished")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deadline := time.Now().Add(cleanGrace)
+
+	var errs []error
+	for _, stage := range s.stages {
+		if err := stage.verifyClean(deadline); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if s.pool != nil {
+		if running := waitForZero(s.pool.running.Load, deadline); running != 0 {
+			errs = append(errs, fmt.Errorf("shared pool: %d goroutines still running", running))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Stage) verifyClean(deadline time.Time) error {
+	var errs []error
+
+	running := waitForZero(func() int64 {
+		return s.started.Load() - s.exited.Load()
+	}, deadline)
+	if running != 0 {
+		errs = append(errs, fmt.Errorf("%d goroutines still running", running))
+	}
+
+	var open int
+	for _, stats := range s.gm.GetAllStats() {
+		if stats.EndTime.IsZero() {
+			open++
+		}
+	}
+	if open != 0 {
+		errs = append(errs, fmt.Errorf("%d goroutines never ended in the tracker", open))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("stage %q: %w", s.Name, errors.Join(errs...))
+}
+
+// waitForZero polls count until it reaches zero or the deadline passes,
+// it returns the last count.
+func waitForZero(count func() int64, deadline time.Time) int64 {
+	for {
+		n := count()
+		if n == 0 || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,126 @@
+package simulator_test
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator"
+	"github.com/AlexsanderHamir/GoFlow/simulator/testkit"
+	"github.com/AlexsanderHamir/IdleSpy/tracker"
+)
+
+func TestShutdownTenThousandGoroutines(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts 10k goroutines")
+	}
+
+	const (
+		routines = 10_000
+		duration = 200 * time.Millisecond
+		budget   = 5 * time.Second
+	)
+
+	baseline := runtime.NumGoroutine()
+
+	sim := simulator.NewSimulator()
+	stages := []*simulator.Stage{
+		simulator.NewStage("Generator", &simulator.StageConfig{
+			RoutineNum:    1,
+			BufferSize:    1024,
+			ItemGenerator: func() any { return 1 },
+		}),
+		simulator.NewStage("Wide", &simulator.StageConfig{
+			RoutineNum: routines,
+			BufferSize: 1024,
+			WorkerFunc: func(item any) (any, error) { return item, nil },
+		}),
+		simulator.NewStage("Sink", &simulator.StageConfig{RoutineNum: 1}),
+	}
+	for _, stage := range stages {
+		if err := sim.AddStage(stage); err != nil {
+			t.Fatalf("Failed to add stage %s: %v", stage.Name, err)
+		}
+	}
+
+	// The overhead past the run's duration is the start and shutdown of
+	// every goroutine.
+	start := time.Now()
+	testkit.RunFor(t, sim, duration)
+	shutdown := time.Since(start) - duration
+	t.Logf("Start and shutdown of %d goroutines took %s", routines, shutdown)
+
+	if shutdown > budget {
+		t.Errorf("Expected shutdown within %s, got %s", budget, shutdown)
+	}
+
+	wide := stages[1]
+	if got := len(wide.Tracker().GetAllStats()); got != routines {
+		t.Errorf("Expected %d tracked goroutines, got %d", routines, got)
+	}
+	testkit.AssertAccounting(t, sim)
+
+	// Exited goroutines may take a moment to leave the count.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > baseline {
+		t.Errorf("Expected at most %d goroutines after the run, got %d", baseline, got)
+	}
+}
+
+func TestVerifyCleanNamesLeakingStage(t *testing.T) {
+	defer simulator.SetCleanGrace(50 * time.Millisecond)()
+
+	sim := testkit.NewPipeline(t, testkit.PipelineOptions{Workers: 2, BufferSize: 4})
+	leaky := sim.GetStages()[2]
+
+	// The first item leaves a helper behind that outlives the run.
+	stuck := make(chan struct{})
+	var once sync.Once
+	leaky.Config.WorkerFunc = func(item any) (any, error) {
+		once.Do(func() {
+			leaky.Spawn(func() { <-stuck })
+		})
+		return item, nil
+	}
+
+	// RunFor would report the leak, the test checks it below.
+	sim.Duration = 50 * time.Millisecond
+	if err := sim.Start(simulator.Nothing); err != nil {
+		t.Fatalf("Failed to run the simulation: %v", err)
+	}
+
+	err := sim.VerifyClean()
+	if err == nil {
+		t.Fatal("Expected the stuck helper to be reported")
+	}
+	if msg := err.Error(); !strings.Contains(msg, `stage "Stage-2": 1 goroutines still running`) {
+		t.Errorf("Expected the error to name Stage-2, got %q", msg)
+	}
+	if msg := err.Error(); strings.Contains(msg, "Stage-1") || strings.Contains(msg, "Sink") {
+		t.Errorf("Expected only Stage-2 to be reported, got %q", msg)
+	}
+
+	close(stuck)
+	if err := sim.VerifyClean(); err != nil {
+		t.Errorf("Expected a clean run once the helper returned, got %v", err)
+	}
+}
+
+func TestVerifyCleanReportsUnendedTracking(t *testing.T) {
+	sim := testkit.NewPipeline(t, testkit.PipelineOptions{Workers: 1})
+	testkit.RunFor(t, sim, 10*time.Millisecond)
+
+	// A goroutine the tracker saw start but never end.
+	worker := sim.GetStages()[1]
+	worker.Tracker().Stats[tracker.GoroutineId(-1)] = &tracker.GoroutineStats{StartTime: time.Now()}
+
+	err := sim.VerifyClean()
+	if err == nil || !strings.Contains(err.Error(), `stage "Stage-1": 1 goroutines never ended in the tracker`) {
+		t.Errorf("Expected Stage-1 to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package testkit_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator/testkit"
+)
+
+// printT is a testkit.TestingT that prints what a test would report, a
+// test passes its *testing.T instead.
+type printT struct {
+	failed bool
+}
+
+func (t *printT) Helper() {}
+
+func (t *printT) Errorf(format string, args ...any) {
+	t.failed = true
+	fmt.Printf(format+"\n", args...)
+}
+
+func (t *printT) Fatalf(format string, args ...any) {
+	t.Errorf(format, args...)
+	panic("test stopped")
+}
+
+func (t *printT) Logf(format string, args ...any) {
+	fmt.Printf(format+"\n", args...)
+}
+
+func (t *printT) Failed() bool {
+	return t.failed
+}
+
+// A pipeline definition is checked from a regular test function: build
+// it, run it with a safety timeout, then audit the accounting.
+func Example() {
+	t := &printT{}
+
+	sim := testkit.NewPipeline(t, testkit.PipelineOptions{
+		Workers:     3,
+		RoutineNum:  2,
+		BufferSize:  16,
+		WorkerDelay: time.Millisecond,
+	})
+
+	testkit.RunFor(t, sim, 100*time.Millisecond)
+	testkit.AssertAccounting(t, sim)
+	testkit.AssertNoDrops(t, sim)
+
+	stages := sim.GetStages()
+	fmt.Println("stages:", len(stages))
+	fmt.Println("consumed items:", stages[len(stages)-1].GetCounts().Consumed > 0)
+	fmt.Println("failed:", t.Failed())
+	// Output:
+	// stages: 5
+	// consumed items: true
+	// failed: false
+}
+
+// A stage that sheds load under backpressure is expected to drop, the
+// accounting must still balance.
+func ExampleAssertAccounting() {
+	t := &printT{}
+
+	sim := testkit.NewPipeline(t, testkit.PipelineOptions{
+		Workers:            2,
+		WorkerDelay:        2 * time.Millisecond,
+		DropOnBackpressure: true,
+	})
+
+	testkit.RunFor(t, sim, 100*time.Millisecond)
+	testkit.AssertAccounting(t, sim)
+
+	var dropped uint64
+	for _, stage := range sim.GetStages() {
+		dropped += stage.GetCounts().Dropped
+	}
+	fmt.Println("shed load:", dropped > 0)
+	fmt.Println("failed:", t.Failed())
+	// Output:
+	// shed load: true
+	// failed: false
+}
+
+// A generated pipeline is fully determined by its seed, a failing seed
+// replays the same stages.
+func ExampleGeneratePipeline() {
+	bounds := testkit.Bounds{MinWorkers: 2, MaxWorkers: 2, MinRoutines: 1, MaxRoutines: 4, MaxBuffer: 8}
+
+	g := testkit.GeneratePipeline(42, bounds)
+	for _, stage := range g.Stages {
+		fmt.Println(stage.Name)
+	}
+	fmt.Println("same stages again:", g.Snippet() == testkit.GeneratePipeline(42, bounds).Snippet())
+	// Output:
+	// Generator
+	// Stage-1
+	// Stage-2
+	// Sink
+	// same stages again: true
+}
+
+// The failures of a FailingWorker depend only on its seed and how many
+// times it was called.
+func ExampleFailingWorker() {
+	work := testkit.FailingWorker(7, 0.5)
+	again := testkit.FailingWorker(7, 0.5)
+
+	same, failed := true, 0
+	for i := range 100 {
+		_, err := work(i)
+		_, errAgain := again(i)
+		same = same && (err == nil) == (errAgain == nil)
+		if err != nil {
+			failed++
+		}
+	}
+	fmt.Println("same failures:", same)
+	fmt.Println("about half failed:", failed > 30 && failed < 70)
+	// Output:
+	// same failures: true
+	// about half failed: true
+}
@@ -0,0 +1,234 @@
+package testkit
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator"
+)
+
+// Bounds limits the random pipelines built by GeneratePipeline. The
+// worker bounds count the stages between the generator and the sink,
+// a pipeline has two stages more.
+type Bounds struct {
+	MinWorkers, MaxWorkers   int
+	MinBuffer, MaxBuffer     int
+	MinRoutines, MaxRoutines int
+	MaxWorkerDelay           time.Duration
+	MaxErrorRate             float64
+	MaxRetries               int
+}
+
+// DefaultBounds keeps the generated pipelines small enough to run in a
+// few hundred milliseconds each, with 3 to 12 stages in total.
+var DefaultBounds = Bounds{
+	MinWorkers:     1,
+	MaxWorkers:     10,
+	MinBuffer:      0,
+	MaxBuffer:      5000,
+	MinRoutines:    1,
+	MaxRoutines:    200,
+	MaxWorkerDelay: 2 * time.Millisecond,
+	MaxErrorRate:   0.3,
+	MaxRetries:     3,
+}
+
+// GeneratedStage is the generated configuration of one stage.
+type GeneratedStage struct {
+	Name               string
+	RoutineNum         int
+	BufferSize         int
+	WorkerDelay        time.Duration
+	ErrorRate          float64
+	RetryCount         int
+	DropOnBackpressure bool
+}
+
+// GeneratedPipeline is a random but valid pipeline, fully determined by
+// its seed and bounds so a failure can be replayed.
+type GeneratedPipeline struct {
+	Seed   uint64
+	Stages []GeneratedStage
+}
+
+// GeneratePipeline derives a pipeline configuration from seed: a
+// generator, a random number of worker stages and a sink, each with
+// random routines, buffers, backpressure, delays and error rates.
+func GeneratePipeline(seed uint64, bounds Bounds) *GeneratedPipeline {
+	r := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+
+	between := func(lo, hi int) int {
+		if hi <= lo {
+			return lo
+		}
+		return lo + r.IntN(hi-lo+1)
+	}
+
+	spec := func(name string) GeneratedStage {
+		return GeneratedStage{
+			Name:               name,
+			RoutineNum:         between(bounds.MinRoutines, bounds.MaxRoutines),
+			BufferSize:         between(bounds.MinBuffer, bounds.MaxBuffer),
+			DropOnBackpressure: r.IntN(2) == 0,
+		}
+	}
+
+	workers := between(bounds.MinWorkers, bounds.MaxWorkers)
+	g := &GeneratedPipeline{Seed: seed}
+
+	g.Stages = append(g.Stages, spec("Generator"))
+	for i := range workers {
+		stage := spec(fmt.Sprintf("Stage-%d", i+1))
+		if bounds.MaxWorkerDelay > 0 {
+			stage.WorkerDelay = time.Duration(r.Int64N(int64(bounds.MaxWorkerDelay)))
+		}
+		stage.ErrorRate = r.Float64() * bounds.MaxErrorRate
+		stage.RetryCount = between(0, bounds.MaxRetries)
+		g.Stages = append(g.Stages, stage)
+	}
+
+	sink := spec("Sink")
+	sink.DropOnBackpressure = false
+	g.Stages = append(g.Stages, sink)
+
+	return g
+}
+
+// Build creates the simulator for the generated configuration.
+func (g *GeneratedPipeline) Build() (*simulator.Simulator, error) {
+	sim := simulator.NewSimulator()
+	last := len(g.Stages) - 1
+
+	for i, spec := range g.Stages {
+		cfg := &simulator.StageConfig{
+			RoutineNum:         spec.RoutineNum,
+			BufferSize:         spec.BufferSize,
+			WorkerDelay:        spec.WorkerDelay,
+			RetryCount:         spec.RetryCount,
+			DropOnBackpressure: spec.DropOnBackpressure,
+		}
+
+		switch i {
+		case 0:
+			cfg.ItemGenerator = func() any { return 1 }
+		case last:
+		default:
+			cfg.WorkerFunc = FailingWorker(g.Seed+uint64(i), spec.ErrorRate)
+		}
+
+		if err := sim.AddStage(simulator.NewStage(spec.Name, cfg)); err != nil {
+			return nil, err
+		}
+	}
+
+	return sim, nil
+}
+
+// Snippet returns Go code that rebuilds the same pipeline, for pasting
+// into a regression test.
+func (g *GeneratedPipeline) Snippet() string {
+	var b strings.Builder
+	last := len(g.Stages) - 1
+
+	fmt.Fprintf(&b, "// seed %d, or: testkit.GeneratePipeline(%d, testkit.DefaultBounds).Build()\n", g.Seed, g.Seed)
+	b.WriteString("sim := simulator.NewSimulator()\n")
+	for i, spec := range g.Stages {
+		fmt.Fprintf(&b, "sim.AddStage(simulator.NewStage(%q, &simulator.StageConfig{\n", spec.Name)
+		fmt.Fprintf(&b, "\tRoutineNum: %d,\n\tBufferSize: %d,\n", spec.RoutineNum, spec.BufferSize)
+		if spec.DropOnBackpressure {
+			b.WriteString("\tDropOnBackpressure: true,\n")
+		}
+
+		switch i {
+		case 0:
+			b.WriteString("\tItemGenerator: func() any { return 1 },\n")
+		case last:
+		default:
+			fmt.Fprintf(&b, "\tWorkerDelay: time.Duration(%d),\n\tRetryCount: %d,\n", spec.WorkerDelay, spec.RetryCount)
+			fmt.Fprintf(&b, "\tWorkerFunc: testkit.FailingWorker(%d, %g),\n", g.Seed+uint64(i), spec.ErrorRate)
+		}
+		b.WriteString("}))\n")
+	}
+
+	return b.String()
+}
+
+var errInjected = errors.New("testkit: injected worker error")
+
+// FailingWorker returns a pass-through WorkerFunc that fails about
+// errorRate of its calls, the failure pattern is derived from seed.
+func FailingWorker(seed uint64, errorRate float64) func(item any) (any, error) {
+	var calls atomic.Uint64
+	threshold := uint64(errorRate * (1 << 53))
+
+	return func(item any) (any, error) {
+		n := calls.Add(1)
+		if mix(seed^n)>>11 < threshold {
+			return nil, errInjected
+		}
+		return item, nil
+	}
+}
+
+// mix is the splitmix64 finalizer, a cheap stateless hash.
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// Corpus holds seeds worth replaying on every test run, add the seeds
+// of fixed failures here so they run in normal test mode:
+//
+//	for _, seed := range testkit.Corpus {
+//		f.Add(seed)
+//	}
+var Corpus = []uint64{1, 2, 3, 42}
+
+// leakGrace is how long CheckSeed waits for goroutines to wind down.
+const leakGrace = 2 * time.Second
+
+// CheckSeed builds the pipeline for seed, runs it for d and asserts that
+// the accounting invariants hold and no goroutine outlived the run. On
+// failure it logs the seed and a snippet to replay it. It counts the
+// process's goroutines, so don't run it in parallel tests. It fits
+// go test -fuzz:
+//
+//	f.Fuzz(func(t *testing.T, seed uint64) {
+//		testkit.CheckSeed(t, seed, testkit.DefaultBounds, 50*time.Millisecond)
+//	})
+func CheckSeed(t TestingT, seed uint64, bounds Bounds, d time.Duration) {
+	t.Helper()
+
+	g := GeneratePipeline(seed, bounds)
+	defer func() {
+		if t.Failed() {
+			t.Logf("testkit: failing seed %d, replay with:\n%s", seed, g.Snippet())
+		}
+	}()
+
+	before := runtime.NumGoroutine()
+
+	sim, err := g.Build()
+	if err != nil {
+		t.Fatalf("testkit: building seed %d: %v", seed, err)
+	}
+
+	RunFor(t, sim, d)
+	AssertAccounting(t, sim)
+
+	deadline := time.Now().Add(leakGrace)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Errorf("testkit: %d goroutines leaked by seed %d", runtime.NumGoroutine()-before, seed)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,49 @@
+package testkit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator/testkit"
+)
+
+// FuzzPipelineConfig runs the seed corpus on every test run, go test
+// -fuzz=FuzzPipelineConfig explores new seeds.
+func FuzzPipelineConfig(f *testing.F) {
+	for _, seed := range testkit.Corpus {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, seed uint64) {
+		testkit.CheckSeed(t, seed, testkit.DefaultBounds, 50*time.Millisecond)
+	})
+}
+
+func TestGeneratePipelineIsReproducible(t *testing.T) {
+	for _, seed := range testkit.Corpus {
+		a := testkit.GeneratePipeline(seed, testkit.DefaultBounds)
+		b := testkit.GeneratePipeline(seed, testkit.DefaultBounds)
+		if a.Snippet() != b.Snippet() {
+			t.Errorf("Expected seed %d to generate the same pipeline twice", seed)
+		}
+	}
+}
+
+func TestGeneratePipelineStageCount(t *testing.T) {
+	const lo, hi = 3, 12
+
+	seen := make(map[int]bool)
+	for seed := range uint64(2000) {
+		n := len(testkit.GeneratePipeline(seed, testkit.DefaultBounds).Stages)
+		if n < lo || n > hi {
+			t.Fatalf("Expected %d to %d stages, seed %d generated %d", lo, hi, seed, n)
+		}
+		seen[n] = true
+	}
+
+	for n := lo; n <= hi; n++ {
+		if !seen[n] {
+			t.Errorf("Expected some seed to generate %d stages", n)
+		}
+	}
+}
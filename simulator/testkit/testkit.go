@@ -0,0 +1,155 @@
+// Package testkit helps users unit test their pipeline definitions: it
+// builds small pipelines, runs them with a safety timeout, and checks
+// that every item the simulator reports is accounted for.
+//
+//	sim := testkit.NewPipeline(t, testkit.PipelineOptions{Workers: 3})
+//	testkit.RunFor(t, sim, 200*time.Millisecond)
+//	testkit.AssertAccounting(t, sim)
+//	testkit.AssertNoDrops(t, sim)
+package testkit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator"
+)
+
+// TestingT is the part of testing.TB the kit uses, *testing.T and
+// *testing.B satisfy it. Like theirs, Fatalf must not return.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+	Fatalf(format string, args ...any)
+	Logf(format string, args ...any)
+	Failed() bool
+}
+
+// runGrace is how long RunFor waits past the run's duration before
+// declaring the simulation stuck.
+const runGrace = 10 * time.Second
+
+// PipelineOptions shapes the pipelines built by NewPipeline, zero values
+// fall back to the simulator defaults.
+type PipelineOptions struct {
+	// Worker stages between the generator and the sink.
+	Workers int
+
+	// Goroutines and buffer size of every stage.
+	RoutineNum int
+	BufferSize int
+
+	// Delay between generated items and per processed item.
+	InputRate   time.Duration
+	WorkerDelay time.Duration
+
+	DropOnBackpressure bool
+}
+
+// NewPipeline builds a generator, opts.Workers pass-through worker
+// stages named Stage-1..Stage-N and a sink.
+func NewPipeline(t TestingT, opts PipelineOptions) *simulator.Simulator {
+	t.Helper()
+
+	routines := max(opts.RoutineNum, 1)
+
+	sim := simulator.NewSimulator()
+
+	generator := simulator.NewStage("Generator", &simulator.StageConfig{
+		InputRate:          opts.InputRate,
+		ItemGenerator:      func() any { return 1 },
+		RoutineNum:         routines,
+		BufferSize:         opts.BufferSize,
+		DropOnBackpressure: opts.DropOnBackpressure,
+	})
+	addStage(t, sim, generator)
+
+	for i := range opts.Workers {
+		stage := simulator.NewStage(fmt.Sprintf("Stage-%d", i+1), &simulator.StageConfig{
+			WorkerFunc:         func(item any) (any, error) { return item, nil },
+			WorkerDelay:        opts.WorkerDelay,
+			RoutineNum:         routines,
+			BufferSize:         opts.BufferSize,
+			DropOnBackpressure: opts.DropOnBackpressure,
+		})
+		addStage(t, sim, stage)
+	}
+
+	sink := simulator.NewStage("Sink", &simulator.StageConfig{
+		RoutineNum: routines,
+		BufferSize: opts.BufferSize,
+	})
+	addStage(t, sim, sink)
+
+	return sim
+}
+
+func addStage(t TestingT, sim *simulator.Simulator, stage *simulator.Stage) {
+	t.Helper()
+
+	if err := sim.AddStage(stage); err != nil {
+		t.Fatalf("testkit: adding stage %s: %v", stage.Name, err)
+	}
+}
+
+// RunFor runs the simulation for d and waits for it to finish, failing
+// the test if it errors, is still running well after d, or leaves
+// goroutines behind.
+func RunFor(t TestingT, sim *simulator.Simulator, d time.Duration) {
+	t.Helper()
+
+	sim.Duration = d
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sim.Start(simulator.Nothing)
+	}()
+
+	timeout := time.NewTimer(d + runGrace)
+	defer timeout.Stop()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("testkit: simulation failed: %v", err)
+		}
+		AssertClean(t, sim)
+	case <-timeout.C:
+		t.Fatalf("testkit: simulation still running %s after its %s duration", runGrace, d)
+	}
+}
+
+// AssertAccounting fails the test for every accounting invariant the
+// simulator reports as violated after the run, see
+// simulator.Simulator.CheckInvariants for the identities.
+func AssertAccounting(t TestingT, sim *simulator.Simulator) {
+	t.Helper()
+
+	for _, v := range sim.CheckInvariants() {
+		t.Errorf("testkit: %s", v)
+	}
+}
+
+// AssertClean fails the test if the finished run left anything behind,
+// see simulator.Simulator.VerifyClean.
+func AssertClean(t TestingT, sim *simulator.Simulator) {
+	t.Helper()
+
+	if err := sim.VerifyClean(); err != nil {
+		t.Errorf("testkit: run not clean: %v", err)
+	}
+}
+
+// AssertNoDrops checks that no stage dropped an item. Items filtered by
+// a worker, consumed by the sink or in flight when the run stopped
+// aren't drops.
+func AssertNoDrops(t TestingT, sim *simulator.Simulator) {
+	t.Helper()
+
+	stages := sim.GetStages()
+	for _, stage := range stages[:len(stages)-1] {
+		if dropped := stage.GetCounts().Dropped; dropped != 0 {
+			t.Errorf("%s: dropped %d items", stage.Name, dropped)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package testutil holds helpers for testing code built on the simulator.
+package testutil
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator"
+)
+
+// FakeClock is a simulator.Clock whose time only moves when Advance is
+// called, so timing-dependent behavior runs deterministically and fast.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a pending After, Sleep or ticker, period is zero for the
+// one-shot ones.
+type waiter struct {
+	deadline time.Time
+	period   time.Duration
+	ch       chan time.Time
+}
+
+var _ simulator.Clock = (*FakeClock)(nil)
+
+// NewFakeClock returns a fake clock set to start.
+func NewFakeClock(start time.Time) *FakeClock {
+	c := &FakeClock{now: start}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep blocks until the clock is advanced by at least d.
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// After returns a channel that receives the fake time once the clock is
+// advanced by at least d.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.addWaiter(&waiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// NewTicker returns a ticker that fires every d of fake time. Like
+// time.Ticker, ticks are dropped when the receiver falls behind.
+func (c *FakeClock) NewTicker(d time.Duration) simulator.Ticker {
+	if d <= 0 {
+		panic("testutil: non-positive interval for NewTicker")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := &waiter{deadline: c.now.Add(d), period: d, ch: make(chan time.Time, 1)}
+	c.addWaiter(w)
+	return &fakeTicker{clock: c, w: w}
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker
+// that comes due, in deadline order.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for len(c.waiters) > 0 && !c.waiters[0].deadline.After(end) {
+		w := c.waiters[0]
+		c.waiters = c.waiters[1:]
+		c.now = w.deadline
+
+		select {
+		case w.ch <- c.now:
+		default:
+		}
+
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+			c.addWaiter(w)
+		}
+	}
+	c.now = end
+}
+
+// BlockUntil waits until at least n timers, sleeps or tickers are
+// pending, so a test can advance the clock once the code under test is
+// known to be waiting on it.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// addWaiter keeps the waiters sorted by deadline, c.mu must be held.
+func (c *FakeClock) addWaiter(w *waiter) {
+	i := sort.Search(len(c.waiters), func(i int) bool {
+		return c.waiters[i].deadline.After(w.deadline)
+	})
+	c.waiters = append(c.waiters, nil)
+	copy(c.waiters[i+1:], c.waiters[i:])
+	c.waiters[i] = w
+	c.cond.Broadcast()
+}
+
+func (c *FakeClock) removeWaiter(w *waiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, pending := range c.waiters {
+		if pending == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	w     *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.ch }
+
+func (t *fakeTicker) Stop() { t.clock.removeWaiter(t.w) }
@@ -0,0 +1,183 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AlexsanderHamir/GoFlow/simulator"
+)
+
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// received returns the value waiting on ch, if any.
+func received(ch <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-ch:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeClockAdvanceFiresDueTimers(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	early := clock.After(10 * time.Millisecond)
+	late := clock.After(30 * time.Millisecond)
+
+	clock.Advance(20 * time.Millisecond)
+
+	if got, ok := received(early); !ok || !got.Equal(epoch.Add(10*time.Millisecond)) {
+		t.Errorf("Expected the early timer to fire at +10ms, got %v (fired %v)", got.Sub(epoch), ok)
+	}
+	if _, ok := received(late); ok {
+		t.Error("Expected the late timer to still be pending")
+	}
+	if got := clock.Now(); !got.Equal(epoch.Add(20 * time.Millisecond)) {
+		t.Errorf("Expected now to be +20ms, got %v", got.Sub(epoch))
+	}
+
+	clock.Advance(10 * time.Millisecond)
+	if got, ok := received(late); !ok || !got.Equal(epoch.Add(30*time.Millisecond)) {
+		t.Errorf("Expected the late timer to fire at +30ms, got %v (fired %v)", got.Sub(epoch), ok)
+	}
+}
+
+func TestFakeClockAfterNonPositiveFiresAtOnce(t *testing.T) {
+	clock := NewFakeClock(epoch)
+
+	if got, ok := received(clock.After(0)); !ok || !got.Equal(epoch) {
+		t.Errorf("Expected an immediate fire at the current time, got %v (fired %v)", got, ok)
+	}
+}
+
+func TestFakeClockTickerRearms(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	ticker := clock.NewTicker(10 * time.Millisecond)
+
+	clock.Advance(10 * time.Millisecond)
+	if got, ok := received(ticker.C()); !ok || !got.Equal(epoch.Add(10*time.Millisecond)) {
+		t.Fatalf("Expected a tick at +10ms, got %v (fired %v)", got.Sub(epoch), ok)
+	}
+
+	// Two ticks come due, the unread one is dropped like time.Ticker.
+	clock.Advance(25 * time.Millisecond)
+	if got, ok := received(ticker.C()); !ok || !got.Equal(epoch.Add(20*time.Millisecond)) {
+		t.Fatalf("Expected a tick at +20ms, got %v (fired %v)", got.Sub(epoch), ok)
+	}
+	if _, ok := received(ticker.C()); ok {
+		t.Fatal("Expected the tick at +30ms to be dropped")
+	}
+
+	clock.Advance(5 * time.Millisecond)
+	if got, ok := received(ticker.C()); !ok || !got.Equal(epoch.Add(40*time.Millisecond)) {
+		t.Fatalf("Expected the re-armed tick at +40ms, got %v (fired %v)", got.Sub(epoch), ok)
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Second)
+	if _, ok := received(ticker.C()); ok {
+		t.Error("Expected no tick after Stop")
+	}
+	if len(clock.waiters) != 0 {
+		t.Errorf("Expected no pending waiters after Stop, got %d", len(clock.waiters))
+	}
+}
+
+func TestFakeClockBlockUntil(t *testing.T) {
+	clock := NewFakeClock(epoch)
+
+	woke := make(chan struct{})
+	go func() {
+		clock.Sleep(50 * time.Millisecond)
+		close(woke)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(49 * time.Millisecond)
+	select {
+	case <-woke:
+		t.Fatal("Expected the sleeper to wait for the full 50ms")
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	select {
+	case <-woke:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the sleeper to wake once the clock reached +50ms")
+	}
+}
+
+func TestFakeClockDrivesGeneration(t *testing.T) {
+	const ticks = 100
+
+	clock := NewFakeClock(epoch)
+	sim := simulator.NewSimulator()
+	sim.Clock = clock
+	sim.Duration = ticks*10*time.Millisecond + 5*time.Millisecond
+
+	generator := simulator.NewStage("Generator", &simulator.StageConfig{
+		RoutineNum:    4,
+		BufferSize:    ticks,
+		InputRate:     10 * time.Millisecond,
+		ItemGenerator: func() any { return 1 },
+	})
+	for _, stage := range []*simulator.Stage{
+		generator,
+		simulator.NewStage("Work", &simulator.StageConfig{
+			RoutineNum: 1,
+			WorkerFunc: func(item any) (any, error) { return item, nil },
+		}),
+		simulator.NewStage("Sink", &simulator.StageConfig{RoutineNum: 1}),
+	} {
+		if err := sim.AddStage(stage); err != nil {
+			t.Fatalf("Failed to add stage %s: %v", stage.Name, err)
+		}
+	}
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- sim.Start(simulator.Nothing) }()
+
+	// The generation pacer and the run's timer.
+	waiting := make(chan struct{})
+	go func() {
+		clock.BlockUntil(2)
+		close(waiting)
+	}()
+	select {
+	case <-waiting:
+	case err := <-done:
+		t.Fatalf("Expected the run to wait on the clock, it returned %v", err)
+	}
+
+	for i := range ticks {
+		clock.Advance(10 * time.Millisecond)
+		waitFor(t, func() bool { return generator.GetCounts().Generated == uint64(i+1) })
+	}
+	clock.Advance(5 * time.Millisecond)
+
+	if err := <-done; err != nil {
+		t.Fatalf("Failed to run the simulation: %v", err)
+	}
+
+	if got := generator.GetCounts().Generated; got != ticks {
+		t.Errorf("Expected exactly %d items over %s of fake time, got %d", ticks, sim.Duration, got)
+	}
+	if elapsed := time.Since(started); elapsed > sim.Duration {
+		t.Errorf("Expected the run to take less wall time than its %s fake duration, took %s", sim.Duration, elapsed)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the condition")
+		}
+		time.Sleep(50 * time.Microsecond)
+	}
+}
@@ -0,0 +1,118 @@
+package simulator
+
+import (
+	"time"
+
+	"github.com/AlexsanderHamir/IdleSpy/tracker"
+)
+
+// waitSampler decides which select-case waits of a single worker are
+// forwarded to the goroutine tracker. It's owned by one goroutine, so it
+// needs no synchronization.
+type waitSampler struct {
+	stage *Stage
+	id    tracker.GoroutineId
+
+	// label is the select case the waits are recorded under.
+	label string
+
+	rate       int
+	maxRecords int
+
+	waits    int
+	recorded int
+	evicted  uint64
+
+	// extraHits and extraTime are the waits the tracker didn't see: the
+	// ones skipped by sampling, with their time estimated from the
+	// sampled waits, and the ones past the record cap. They're folded
+	// into the tracker's totals on flush, so the hit counts and totals
+	// stay complete while only the per-wait latencies are bounded.
+	extraHits int
+	extraTime time.Duration
+
+	sampled bool
+	start   time.Time
+}
+
+func newWaitSampler(stage *Stage, id tracker.GoroutineId) *waitSampler {
+	return &waitSampler{
+		stage:      stage,
+		id:         id,
+		label:      stage.Name,
+		rate:       stage.sampleRate(),
+		maxRecords: stage.Config.TrackingMaxRecords,
+	}
+}
+
+// newOutputSampler samples a worker's sends blocked on backpressure,
+// they're recorded under a select case of their own so the input waits
+// read the same as without it.
+func newOutputSampler(stage *Stage, id tracker.GoroutineId) *waitSampler {
+	w := newWaitSampler(stage, id)
+	w.label = outputCaseLabel(stage.Name)
+	return w
+}
+
+// outputCaseLabel is the select case of a stage's backpressure waits.
+func outputCaseLabel(stageName string) string {
+	return stageName + " output"
+}
+
+// begin is called right before the worker blocks.
+func (w *waitSampler) begin() {
+	w.sampled = w.waits%w.rate == 0
+	if w.sampled {
+		w.start = w.stage.now()
+	}
+	w.waits++
+}
+
+// end records the wait started by begin if it was sampled. Once the
+// worker reached its record cap the newest waits are only aggregated
+// into the totals instead of growing the tracker's latency list, and
+// counted as evictions.
+func (w *waitSampler) end() {
+	if !w.sampled {
+		w.extraHits++
+		return
+	}
+
+	latency := w.stage.now().Sub(w.start)
+	if w.maxRecords > 0 && w.recorded >= w.maxRecords {
+		w.evicted++
+		w.extraHits++
+		w.extraTime += latency * time.Duration(w.rate)
+		return
+	}
+
+	w.stage.gm.TrackSelectCase(w.label, latency, w.id)
+	w.extraTime += latency * time.Duration(w.rate-1)
+	w.recorded++
+}
+
+// flush publishes the eviction count and folds the waits the tracker
+// didn't see into its totals, called once when the worker exits.
+func (w *waitSampler) flush() {
+	if w.evicted > 0 {
+		w.stage.metrics.recordEvictions(w.evicted)
+	}
+
+	if w.extraHits == 0 && w.extraTime == 0 {
+		return
+	}
+
+	stats := w.stage.gm.GetGoroutineStats(w.id)
+	if stats == nil {
+		return
+	}
+
+	// Only this goroutine writes its own select stats.
+	selectStats := stats.GetSelectCaseStats(w.label)
+	if selectStats == nil {
+		return
+	}
+
+	selectStats.CaseHits += w.extraHits
+	selectStats.BlockedCaseTime += w.extraTime
+}
@@ -0,0 +1,155 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitSamplerScalesCountsNotLatencies(t *testing.T) {
+	const waits = 100
+	latency := time.Millisecond
+
+	stage, sampler := trackedStage(&StageConfig{RoutineNum: 1, TrackingSampleRate: 4}, &stepClock{step: latency})
+	for range waits {
+		sampler.begin()
+		sampler.end()
+	}
+	sampler.flush()
+
+	stats := stage.gm.GetGoroutineStats(sampler.id).GetSelectCaseStats(stage.Name)
+	if stats == nil {
+		t.Fatal("Expected the sampled waits to be tracked")
+	}
+
+	if stats.GetCaseHits() != waits {
+		t.Errorf("Expected %d hits, got %d", waits, stats.GetCaseHits())
+	}
+
+	if want := waits * latency; stats.GetCaseTime() != want {
+		t.Errorf("Expected %v total blocked time, got %v", want, stats.GetCaseTime())
+	}
+
+	if stats.GetAverage() != latency {
+		t.Errorf("Expected %v average, got %v", latency, stats.GetAverage())
+	}
+
+	for _, p := range []float64{50, 90, 99} {
+		if got := stats.GetPercentile(p); got != latency {
+			t.Errorf("Expected p%v of %v, got %v", p, latency, got)
+		}
+	}
+}
+
+func TestWaitSamplerExactByDefault(t *testing.T) {
+	latency := time.Millisecond
+
+	stage, sampler := trackedStage(&StageConfig{RoutineNum: 1}, &stepClock{step: latency})
+	for range 10 {
+		sampler.begin()
+		sampler.end()
+	}
+	sampler.flush()
+
+	stats := stage.gm.GetGoroutineStats(sampler.id).GetSelectCaseStats(stage.Name)
+	if stats.GetCaseHits() != 10 || stats.GetCaseTime() != 10*latency {
+		t.Errorf("Expected 10 hits and %v, got %d hits and %v", 10*latency, stats.GetCaseHits(), stats.GetCaseTime())
+	}
+}
+
+// BenchmarkWaitTracking measures the tracking cost of a single select
+// wait in the exact, coarse clock and sampled modes.
+func BenchmarkWaitTracking(b *testing.B) {
+	modes := []struct {
+		name   string
+		config StageConfig
+	}{
+		{"exact", StageConfig{RoutineNum: 1}},
+		{"coarse", StageConfig{RoutineNum: 1, TrackingResolution: time.Millisecond}},
+		{"sampled", StageConfig{RoutineNum: 1, TrackingSampleRate: 16}},
+	}
+
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			config := mode.config
+			stage, sampler := trackedStage(&config, RealClock{})
+			if config.TrackingResolution > 0 {
+				stage.coarse = newCoarseClock(RealClock{}, config.TrackingResolution, stage.spawn)
+				defer stage.coarse.stop()
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				sampler.begin()
+				sampler.end()
+			}
+		})
+	}
+}
+
+func TestWaitSamplerCapKeepsTotals(t *testing.T) {
+	const (
+		waits      = 500
+		maxRecords = 100
+	)
+	latency := time.Millisecond
+
+	stage, sampler := trackedStage(&StageConfig{RoutineNum: 1, TrackingMaxRecords: maxRecords}, &stepClock{step: latency})
+	for range waits {
+		sampler.begin()
+		sampler.end()
+	}
+	sampler.flush()
+
+	stats := stage.gm.GetGoroutineStats(sampler.id).GetSelectCaseStats(stage.Name)
+	if stats.GetCaseHits() != waits {
+		t.Errorf("Expected %d hits including the evicted waits, got %d", waits, stats.GetCaseHits())
+	}
+
+	if want := waits * latency; stats.GetCaseTime() != want {
+		t.Errorf("Expected %v total blocked time including the evicted waits, got %v", want, stats.GetCaseTime())
+	}
+
+	if evicted := stage.metrics.loadEvictions(); evicted != waits-maxRecords {
+		t.Errorf("Expected %d evictions, got %d", waits-maxRecords, evicted)
+	}
+}
+
+// TestWaitSamplerCapSoak runs a capped sampler for a long time and checks
+// its memory stays flat once the cap is reached.
+func TestWaitSamplerCapSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+
+	const (
+		maxRecords = 1000
+		warmup     = 10_000
+		soak       = 1_000_000
+	)
+
+	stage, sampler := trackedStage(&StageConfig{RoutineNum: 1, TrackingMaxRecords: maxRecords}, &stepClock{step: time.Microsecond})
+	wait := func(n int) {
+		for range n {
+			sampler.begin()
+			sampler.end()
+		}
+	}
+
+	wait(warmup)
+	before := heapAlloc()
+	wait(soak)
+	after := heapAlloc()
+
+	// Uncapped, the tracker would keep 8 bytes per wait, about 8MB.
+	const budget = 64 << 10
+	if after > before && after-before > budget {
+		t.Errorf("Expected the heap to stay flat past the cap, grew by %d bytes", after-before)
+	}
+
+	sampler.flush()
+	stats := stage.gm.GetGoroutineStats(sampler.id).GetSelectCaseStats(stage.Name)
+	if stats.GetCaseHits() != warmup+soak {
+		t.Errorf("Expected %d hits, got %d", warmup+soak, stats.GetCaseHits())
+	}
+}
@@ -0,0 +1,134 @@
+package simulator
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// fixtureCounts are the counters a fixture stage reports.
+type fixtureCounts struct {
+	generated, received, processed, output, dropped uint64
+}
+
+// fixtureStage builds a stage whose metrics report counts over a window
+// of exactly seconds, so the rates are deterministic.
+func fixtureStage(name string, counts fixtureCounts, seconds int) *Stage {
+	stage := NewStage(name, &StageConfig{RoutineNum: 1})
+
+	m := stage.metrics
+	atomic.StoreUint64(&m.generatedItems, counts.generated)
+	atomic.StoreUint64(&m.receivedItems, counts.received)
+	atomic.StoreUint64(&m.processedItems, counts.processed)
+	atomic.StoreUint64(&m.outputItems, counts.output)
+	atomic.StoreUint64(&m.droppedItems, counts.dropped)
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.startTime = start
+	m.endTime = start.Add(time.Duration(seconds) * time.Second)
+
+	return stage
+}
+
+// fixturePipeline is a generator, three worker stages with distinct
+// numbers and a sink, over a ten second window. Store only ran for four
+// seconds, so it has the highest throughput while being last in flow.
+func fixturePipeline() []*Stage {
+	stages := []*Stage{
+		fixtureStage("Generator", fixtureCounts{generated: 1000, output: 900, dropped: 100}, 10),
+		fixtureStage("Parse", fixtureCounts{received: 900, processed: 800, output: 800, dropped: 100}, 10),
+		fixtureStage("Enrich", fixtureCounts{received: 800, processed: 800, output: 600, dropped: 200}, 10),
+		fixtureStage("Store", fixtureCounts{received: 600, processed: 400, output: 400, dropped: 200}, 4),
+		fixtureStage("Sink", fixtureCounts{received: 400}, 10),
+	}
+	stages[0].isGenerator = true
+	stages[len(stages)-1].isFinal = true
+
+	return stages
+}
+
+// captureStdout returns what fn printed to the standard output.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+
+	fn()
+	w.Close()
+
+	return string(<-done)
+}
+
+// checkGolden compares got with testdata/name, -update rewrites it.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatalf("Failed to update %s: %v", path, err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+
+	if got != string(want) {
+		t.Errorf("Output doesn't match %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// stepClock is a Clock whose Now moves by step on every call, so every
+// begin and end pair of a wait measures exactly step. It isn't safe for
+// concurrent use.
+type stepClock struct {
+	RealClock
+	now  time.Time
+	step time.Duration
+}
+
+func (c *stepClock) Now() time.Time {
+	c.now = c.now.Add(c.step)
+	return c.now
+}
+
+// trackedStage returns a worker stage with a tracked goroutine and its
+// sampler, timed by clock.
+func trackedStage(config *StageConfig, clock Clock) (*Stage, *waitSampler) {
+	stage := NewStage("Worker", config)
+	stage.clock = clock
+	id := stage.gm.TrackGoroutineStart()
+
+	return stage, newWaitSampler(stage, id)
+}
+
+// heapAlloc returns the live heap after a collection.
+func heapAlloc() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}